@@ -3,21 +3,55 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
+	"fmt"
 	kumaRepoter "git.ghink.net/ghink/kuma-repoter"
 	"git.ghink.net/ghink/kuma-repoter/internal/method"
+	"git.ghink.net/ghink/kuma-repoter/internal/version"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
-func loadConfig() (kumaRepoter.Config, error) {
-	viper.SetConfigName("config")
-	viper.SetConfigType("json")
-	viper.AddConfigPath(".")
+// Exit statuses. The daemon runs until signalled and exits with 0 unless the
+// config cannot be loaded or is invalid; a -once run also reports whether a
+// target was down or a report could not be delivered.
+const (
+	exitConfigError   = 1
+	exitPingFailure   = 2
+	exitReportFailure = 3
+)
+
+// targetEntry is a targets item of the config file, which gives durations
+// in seconds like the top-level options.
+type targetEntry struct {
+	kumaRepoter.MonitorTarget `mapstructure:",squash"`
+	ReportPeriodSeconds       int `mapstructure:"report_period_seconds"`
+	RetryDelaySeconds         int `mapstructure:"retry_delay_seconds"`
+}
+
+func loadConfig(configFile string) (kumaRepoter.Config, error) {
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		viper.SetConfigName("config")
+		viper.AddConfigPath(".")
+	}
+	// Every key can be set as UPTIME_<KEY>, also without any config file.
+	// Dots and dashes map to underscores, e.g. a.b-c is UPTIME_A_B_C
+	viper.SetEnvPrefix("UPTIME")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.AutomaticEnv()
+
+	// The format follows the file extension (json, yaml, toml, ...) unless overridden
+	if format := os.Getenv("UPTIME_CONFIG_FORMAT"); format != "" {
+		viper.SetConfigType(format)
+	}
 
 	viper.SetDefault("ping_host", "oss-cn-beijing.aliyuncs.com")
 	viper.SetDefault("report_period_seconds", 40)
@@ -26,71 +60,245 @@ func loadConfig() (kumaRepoter.Config, error) {
 	viper.SetDefault("ping_count", 4)
 	viper.SetDefault("ping_timeout_seconds", 10)
 	viper.SetDefault("http_timeout_seconds", 15)
+	viper.SetDefault("report_protocol", "kuma")
+	viper.SetDefault("report_method", "GET")
+	viper.SetDefault("max_concurrent_reports", 1)
+	viper.SetDefault("shutdown_timeout_seconds", 10)
 	viper.SetDefault("status_message", "OK")
 	viper.SetDefault("use_ipv4", true)
+	viper.SetDefault("privileged_ping", true)
+	viper.SetDefault("report_ping_value", true)
+	viper.SetDefault("fallback_tcp_port", 443)
 	viper.SetDefault("use_ipv6", false)
 	viper.SetDefault("use_system_ping", runtime.GOOS == "darwin")
+	viper.SetDefault("check_mode", "icmp")
+	viper.SetDefault("degraded_loss_threshold", 0)
+	viper.SetDefault("log_level", "INFO")
+	viper.SetDefault("circuit_probe_interval_seconds", 300)
+	viper.SetDefault("log_max_size_mb", 10)
+	viper.SetDefault("log_max_backups", 3)
 
 	if err := viper.ReadInConfig(); err != nil {
 		var configFileNotFoundError viper.ConfigFileNotFoundError
-		if errors.As(err, &configFileNotFoundError) {
-			method.DefaultLogger("WARN", "Config file not found, using defaults")
+		if configFile != "" || !errors.As(err, &configFileNotFoundError) {
+			return kumaRepoter.Config{}, err
 		}
+		method.DefaultLogger("WARN", "Config file not found, using defaults")
 	}
 
-	viper.AutomaticEnv()
-	viper.SetEnvPrefix("UPTIME")
+	var targetEntries []targetEntry
+	if err := viper.UnmarshalKey("targets", &targetEntries); err != nil {
+		return kumaRepoter.Config{}, err
+	}
+
+	var paramNames kumaRepoter.ParamNames
+	if err := viper.UnmarshalKey("param_names", &paramNames); err != nil {
+		return kumaRepoter.Config{}, err
+	}
+
+	var simulatedRTTs []float64
+	if err := viper.UnmarshalKey("simulated_rtts", &simulatedRTTs); err != nil {
+		return kumaRepoter.Config{}, err
+	}
+
+	var targets []kumaRepoter.MonitorTarget
+	for _, entry := range targetEntries {
+		target := entry.MonitorTarget
+		target.ReportPeriod = time.Duration(entry.ReportPeriodSeconds) * time.Second
+		target.RetryDelay = time.Duration(entry.RetryDelaySeconds) * time.Second
+		targets = append(targets, target)
+	}
 
 	return kumaRepoter.Config{
-		ReportURL:     viper.GetString("report_url"),
-		PingHost:      viper.GetString("ping_host"),
-		ReportPeriod:  time.Duration(viper.GetInt("report_period_seconds")) * time.Second,
-		MaxRetries:    viper.GetInt("max_retries"),
-		RetryDelay:    time.Duration(viper.GetInt("retry_delay_seconds")) * time.Second,
-		PingCount:     viper.GetInt("ping_count"),
-		PingTimeout:   time.Duration(viper.GetInt("ping_timeout_seconds")) * time.Second,
-		HTTPTimeout:   time.Duration(viper.GetInt("http_timeout_seconds")) * time.Second,
-		StatusMessage: viper.GetString("status_message"),
-		UseIPv4:       viper.GetBool("use_ipv4"),
-		UseIPv6:       viper.GetBool("use_ipv6"),
-		UseSystemPing: viper.GetBool("use_system_ping"),
+		ReportURL:                viper.GetString("report_url"),
+		ReportURLs:               viper.GetStringSlice("report_urls"),
+		ReportURLReprobeInterval: time.Duration(viper.GetInt("report_url_reprobe_interval_seconds")) * time.Second,
+		PingHost:                 viper.GetString("ping_host"),
+		ReportPeriod:             time.Duration(viper.GetInt("report_period_seconds")) * time.Second,
+		ReportPeriodJitter:       time.Duration(viper.GetInt("report_period_jitter_seconds")) * time.Second,
+		StartupDelay:             time.Duration(viper.GetInt("startup_delay_seconds")) * time.Second,
+		StartupDelayJitter:       time.Duration(viper.GetInt("startup_delay_jitter_seconds")) * time.Second,
+		MaxRetries:               viper.GetInt("max_retries"),
+		RetryDelay:               time.Duration(viper.GetInt("retry_delay_seconds")) * time.Second,
+		MaxRetryDuration:         time.Duration(viper.GetInt("max_retry_duration_seconds")) * time.Second,
+		PingCount:                viper.GetInt("ping_count"),
+		PingDeadline:             time.Duration(viper.GetInt("ping_deadline_seconds")) * time.Second,
+		PingPacketTimeout:        time.Duration(viper.GetInt("ping_packet_timeout_ms")) * time.Millisecond,
+		PingTimeout:              time.Duration(viper.GetInt("ping_timeout_seconds")) * time.Second,
+		TrimOutliers:             viper.GetBool("trim_outliers"),
+		PingInterval:             time.Duration(viper.GetInt("ping_interval_ms")) * time.Millisecond,
+		PingPacketSize:           viper.GetInt("ping_packet_size"),
+		HTTPTimeout:              time.Duration(viper.GetInt("http_timeout_seconds")) * time.Second,
+		ReportProtocol:           viper.GetString("report_protocol"),
+		ReportTransport:          viper.GetString("report_transport"),
+		ReportMethod:             viper.GetString("report_method"),
+		ReportBodyTemplate:       viper.GetString("report_body_template"),
+		IncludeMetadata:          viper.GetBool("include_metadata"),
+		SourceName:               viper.GetString("source_name"),
+		UserAgent:                viper.GetString("user_agent"),
+		OmitPingValue:            !viper.GetBool("report_ping_value"),
+		IdempotencyKey:           viper.GetBool("idempotency_key"),
+		InjectTraceparent:        viper.GetBool("inject_traceparent"),
+		CorrelationIDHeader:      viper.GetString("correlation_id_header"),
+		ReportHeaders:            viper.GetStringMapString("report_headers"),
+		ParamNames:               paramNames,
+		AcceptableReportStatus:   viper.GetIntSlice("acceptable_report_status"),
+		ProxyURL:                 viper.GetString("proxy_url"),
+		DryRun:                   viper.GetBool("dry_run"),
+		TLSSkipVerify:            viper.GetBool("tls_skip_verify"),
+		TLSCACertFile:            viper.GetString("tls_ca_cert_file"),
+		TLSClientCert:            viper.GetString("tls_client_cert"),
+		TLSClientKey:             viper.GetString("tls_client_key"),
+		StatusMessage:            viper.GetString("status_message"),
+		UseIPv4:                  viper.GetBool("use_ipv4"),
+		DualStack:                viper.GetBool("dual_stack"),
+		SystemPingPath:           viper.GetString("system_ping_path"),
+		SystemPing6Path:          viper.GetString("system_ping6_path"),
+		AutoFallbackPing:         viper.GetBool("auto_fallback_ping"),
+		PrivilegedPing:           viper.GetBool("privileged_ping"),
+		FallbackToTCP:            viper.GetBool("fallback_to_tcp"),
+		FallbackTCPPort:          viper.GetInt("fallback_tcp_port"),
+		UseIPv6:                  viper.GetBool("use_ipv6"),
+		UseSystemPing:            viper.GetBool("use_system_ping"),
+		PingAllIPs:               viper.GetBool("ping_all_ips"),
+		PingAllIPsAggregate:      viper.GetString("ping_all_ips_aggregate"),
+		PingHostAggregate:        viper.GetString("ping_host_aggregate"),
+		HostOverrides:            viper.GetStringMapString("host_overrides"),
+		DNSServer:                viper.GetString("dns_server"),
+		DNSCacheTTL:              time.Duration(viper.GetInt("dns_cache_ttl_seconds")) * time.Second,
+		UseStaleDNSOnError:       viper.GetBool("use_stale_dns_on_error"),
+		CheckMode:                viper.GetString("check_mode"),
+		CheckURL:                 viper.GetString("check_url"),
+		ExpectedStatusCodes:      viper.GetIntSlice("expected_status_codes"),
+		SimulatedRTTs:            simulatedRTTs,
+		LossDownThreshold:        viper.GetFloat64("loss_down_threshold"),
+		FlapThreshold:            viper.GetInt("flap_threshold"),
+		SmoothingWindow:          viper.GetInt("smoothing_window"),
+		DegradedLossThreshold:    viper.GetFloat64("degraded_loss_threshold"),
+		Targets:                  targets,
+		LogLevel:                 viper.GetString("log_level"),
+		LogFormat:                viper.GetString("log_format"),
+		LogCaller:                viper.GetBool("log_caller"),
+		LogFile:                  viper.GetString("log_file"),
+		LogMaxSizeMB:             viper.GetInt("log_max_size_mb"),
+		LogMaxBackups:            viper.GetInt("log_max_backups"),
+		MaxConcurrentReports:     viper.GetInt("max_concurrent_reports"),
+		MaxReportsPerMinute:      viper.GetInt("max_reports_per_minute"),
+		CircuitBreakerThreshold:  viper.GetInt("circuit_breaker_threshold"),
+		CircuitProbeInterval:     time.Duration(viper.GetInt("circuit_probe_interval_seconds")) * time.Second,
+		DetectClockJumps:         viper.GetBool("detect_clock_jumps"),
+		MinReportGap:             time.Duration(viper.GetInt("min_report_gap_seconds")) * time.Second,
+		ShutdownTimeout:          time.Duration(viper.GetInt("shutdown_timeout_seconds")) * time.Second,
+		StateFilePath:            viper.GetString("state_file_path"),
+		ReportDownOnShutdown:     viper.GetBool("report_down_on_shutdown"),
+		ShutdownMessage:          viper.GetString("shutdown_message"),
+		MetricsListenAddr:        viper.GetString("metrics_listen_addr"),
+		OTLPEndpoint:             viper.GetString("otlp_endpoint"),
+		HealthListenAddr:         viper.GetString("health_listen_addr"),
 	}, nil
 }
 
 func main() {
-	cfg, err := loadConfig()
+	configFile := flag.String("config", os.Getenv("UPTIME_CONFIG_FILE"), "path to the config file")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	once := flag.Bool("once", false, "report once for every target and exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println("kuma-reporter", version.String())
+		return
+	}
+
+	cfg, err := loadConfig(*configFile)
 	if err != nil {
-		method.DefaultLogger("FATAL", "Failed to load configuration: %v", err)
-		panic(err)
+		method.DefaultLogger("FATAL", "Failed to load configuration: ", err)
+		os.Exit(exitConfigError)
 	}
 
-	if cfg.ReportURL == "" {
-		method.DefaultLogger("FATAL", "Missing 'report_url'")
-		panic("Missing 'report_url'")
+	if err := cfg.Validate(); err != nil {
+		method.DefaultLogger("FATAL", "Invalid configuration: ", err)
+		os.Exit(exitConfigError)
 	}
 
-	method.DefaultLogger("INFO", "Uptime Kuma Reporter starting with configuration:")
-	method.DefaultLogger("INFO", "  Report URL: ", cfg.ReportURL)
-	method.DefaultLogger("INFO", "  Ping Host: ", cfg.PingHost)
+	method.DefaultLogger("INFO", "Uptime Kuma Reporter ", version.String(), " starting with configuration:")
+	if len(cfg.Targets) == 0 {
+		method.DefaultLogger("INFO", "  Report URL: ", method.RedactURLString(cfg.ReportURL))
+		method.DefaultLogger("INFO", "  Ping Host: ", cfg.PingHost)
+	}
+	for _, target := range cfg.Targets {
+		method.DefaultLogger("INFO", "  Target: ", target.Host, " -> ", method.RedactURLString(target.ReportURL))
+	}
 	method.DefaultLogger("INFO", "  Report Period: ", cfg.ReportPeriod)
 	method.DefaultLogger("INFO", "  Max Retries: ", cfg.MaxRetries)
 	method.DefaultLogger("INFO", "  Use IPv4: ", cfg.UseIPv4, ", Use IPv6: ", cfg.UseIPv6)
+	method.DefaultLogger("INFO", "  Check Mode: ", cfg.CheckMode)
 	method.DefaultLogger("INFO", "  Use System Ping: ", cfg.UseSystemPing)
+	if cfg.DryRun {
+		method.DefaultLogger("WARN", "Dry run: reports are logged, not sent")
+	}
 
 	if cfg.UseSystemPing && runtime.GOOS == "darwin" {
 		method.DefaultLogger("WARN", "macOS detected: Using system ping command to avoid permission issues")
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		<-sigCh
-		method.DefaultLogger("INFO", "Shutting down...")
-		cancel()
+		sig := <-sigCh
+		method.DefaultLogger("INFO", "Shutting down on ", sig, "...")
+		cancel(shutdownCause(sig))
+	}()
+
+	if *once {
+		os.Exit(onceExitStatus(kumaRepoter.RunOnce(ctx, cfg)))
+	}
+
+	reload := make(chan kumaRepoter.Config)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			method.DefaultLogger("INFO", "SIGHUP received, reloading configuration")
+			next, err := loadConfig(*configFile)
+			if err != nil {
+				method.DefaultLogger("ERROR", "Failed to reload configuration: ", err)
+				continue
+			}
+			select {
+			case reload <- next:
+			case <-ctx.Done():
+				return
+			}
+		}
 	}()
 
-	kumaRepoter.Daemon(ctx, cfg)
+	kumaRepoter.DaemonWithReload(ctx, cfg, reload)
+}
+
+// onceExitStatus maps the outcome of a -once run to its exit status, a down
+// target before an undelivered report.
+func onceExitStatus(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, kumaRepoter.ErrPingFailed):
+		return exitPingFailure
+	case errors.Is(err, kumaRepoter.ErrReportFailed):
+		return exitReportFailure
+	default:
+		return exitConfigError
+	}
+}
+
+// shutdownCause maps SIGTERM, which orchestrators send before killing, to a
+// graceful drain and SIGINT (Ctrl-C) to an immediate stop.
+func shutdownCause(sig os.Signal) error {
+	if sig == syscall.SIGTERM {
+		return kumaRepoter.ErrGracefulShutdown
+	}
+
+	return context.Canceled
 }
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	kumaRepoter "git.ghink.net/ghink/kuma-repoter"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// runMainEnv makes the test binary run main instead of the tests, so the
+// exit status of the real binary can be checked.
+const runMainEnv = "KUMA_REPORTER_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestExitStatus(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"report_url": "https://kuma.example.com/api/push/test", "report_period_seconds": 0}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	malformed := filepath.Join(dir, "malformed.json")
+	if err := os.WriteFile(malformed, []byte(`{"report_url": `), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	kuma := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/push/test" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer kuma.Close()
+	onceConfig := func(name, path, rtt string) string {
+		file := filepath.Join(dir, name+".json")
+		config := `{"report_url": "` + kuma.URL + path + `", "simulated_rtts": [` + rtt + `], "max_retries": 1, "retry_delay_seconds": 0}`
+		if err := os.WriteFile(file, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	up := onceConfig("up", "/api/push/test", "12.5")
+	down := onceConfig("down", "/api/push/test", "-1")
+	undelivered := onceConfig("undelivered", "/api/push/broken", "12.5")
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "version", args: []string{"-version"}, want: 0},
+		{name: "invalid config", args: []string{"-config", invalid}, want: exitConfigError},
+		{name: "malformed config", args: []string{"-config", malformed}, want: exitConfigError},
+		{name: "missing config", args: []string{"-config", filepath.Join(dir, "missing.json")}, want: exitConfigError},
+		{name: "once up", args: []string{"-once", "-config", up}, want: 0},
+		{name: "once ping failure", args: []string{"-once", "-config", down}, want: exitPingFailure},
+		{name: "once report failure", args: []string{"-once", "-config", undelivered}, want: exitReportFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], tt.args...)
+			cmd.Env = append(os.Environ(), runMainEnv+"=1")
+
+			err := cmd.Run()
+			status := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				status = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.want {
+				t.Errorf("exit status %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	type fields struct {
+		reportURL    string
+		pingHost     string
+		reportPeriod time.Duration
+		httpTimeout  time.Duration
+		maxRetries   int
+		useIPv6      bool
+		reportMethod string
+	}
+
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want fields
+	}{
+		{
+			name: "defaults",
+			want: fields{pingHost: "oss-cn-beijing.aliyuncs.com", reportPeriod: 40 * time.Second, httpTimeout: 15 * time.Second, maxRetries: 3, reportMethod: "GET"},
+		},
+		{
+			name: "env only",
+			env: map[string]string{
+				"UPTIME_REPORT_URL":            "https://kuma.example.com/api/push/env",
+				"UPTIME_PING_HOST":             "env.example.com",
+				"UPTIME_REPORT_PERIOD_SECONDS": "20",
+				"UPTIME_HTTP_TIMEOUT_SECONDS":  "7",
+				"UPTIME_MAX_RETRIES":           "5",
+				"UPTIME_USE_IPV6":              "true",
+				"UPTIME_REPORT_METHOD":         "POST",
+			},
+			want: fields{
+				reportURL: "https://kuma.example.com/api/push/env", pingHost: "env.example.com", reportPeriod: 20 * time.Second,
+				httpTimeout: 7 * time.Second, maxRetries: 5, useIPv6: true, reportMethod: "POST",
+			},
+		},
+		{
+			name: "env over the file",
+			file: `{"report_url": "https://kuma.example.com/api/push/file", "ping_host": "file.example.com", "report_period_seconds": 60}`,
+			env:  map[string]string{"UPTIME_REPORT_PERIOD_SECONDS": "20"},
+			want: fields{
+				reportURL: "https://kuma.example.com/api/push/file", pingHost: "file.example.com", reportPeriod: 20 * time.Second,
+				httpTimeout: 15 * time.Second, maxRetries: 3, reportMethod: "GET",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Chdir(dir)
+			if tt.file != "" {
+				if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			viper.Reset()
+			defer viper.Reset()
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := fields{
+				reportURL:    cfg.ReportURL,
+				pingHost:     cfg.PingHost,
+				reportPeriod: cfg.ReportPeriod,
+				httpTimeout:  cfg.HTTPTimeout,
+				maxRetries:   cfg.MaxRetries,
+				useIPv6:      cfg.UseIPv6,
+				reportMethod: cfg.ReportMethod,
+			}
+			if got != tt.want {
+				t.Errorf("loadConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShutdownCause(t *testing.T) {
+	tests := []struct {
+		name         string
+		sig          os.Signal
+		want         error
+		wantRequests int64
+	}{
+		{name: "SIGTERM drains", sig: syscall.SIGTERM, want: kumaRepoter.ErrGracefulShutdown, wantRequests: 2},
+		{name: "SIGINT stops", sig: syscall.SIGINT, want: context.Canceled, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cause := shutdownCause(tt.sig)
+			if !errors.Is(cause, tt.want) {
+				t.Fatalf("shutdownCause(%s) = %v, want %v", tt.sig, cause, tt.want)
+			}
+
+			var requests, results atomic.Int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				requests.Add(1)
+			}))
+			defer srv.Close()
+
+			cfg := kumaRepoter.Config{
+				ReportURL:       srv.URL + "/api/push/test",
+				PingHost:        "example.com",
+				PingCount:       1,
+				PingDeadline:    time.Second,
+				ReportPeriod:    time.Minute,
+				MaxRetries:      1,
+				HTTPTimeout:     5 * time.Second,
+				UseIPv4:         true,
+				ShutdownTimeout: 5 * time.Second,
+				Logger:          kumaRepoter.NopLogger,
+				PingProvider:    kumaRepoter.NewSimulatedPingProvider([]float64{10}),
+				OnResult:        func(kumaRepoter.ReportResult) { results.Add(1) },
+			}
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				kumaRepoter.Daemon(ctx, cfg)
+			}()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for results.Load() == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("initial report did not finish")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			cancel(cause)
+			<-done
+
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("sent %d reports, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+// envCase sets one option through its environment variable and reads back
+// the Config field it ends up in.
+type envCase struct {
+	key   string
+	value string
+	got   func(kumaRepoter.Config) any
+	want  any
+}
+
+func envCases() []envCase {
+	s := time.Second
+	ms := time.Millisecond
+	return []envCase{
+		{"report_url", "https://kuma.example.com/api/push/env", func(c kumaRepoter.Config) any { return c.ReportURL }, "https://kuma.example.com/api/push/env"},
+		{"report_url_reprobe_interval_seconds", "120", func(c kumaRepoter.Config) any { return c.ReportURLReprobeInterval }, 120 * s},
+		{"ping_host", "env.example.com", func(c kumaRepoter.Config) any { return c.PingHost }, "env.example.com"},
+		{"report_period_seconds", "20", func(c kumaRepoter.Config) any { return c.ReportPeriod }, 20 * s},
+		{"report_period_jitter_seconds", "3", func(c kumaRepoter.Config) any { return c.ReportPeriodJitter }, 3 * s},
+		{"startup_delay_seconds", "4", func(c kumaRepoter.Config) any { return c.StartupDelay }, 4 * s},
+		{"startup_delay_jitter_seconds", "5", func(c kumaRepoter.Config) any { return c.StartupDelayJitter }, 5 * s},
+		{"max_retries", "6", func(c kumaRepoter.Config) any { return c.MaxRetries }, 6},
+		{"retry_delay_seconds", "7", func(c kumaRepoter.Config) any { return c.RetryDelay }, 7 * s},
+		{"max_retry_duration_seconds", "8", func(c kumaRepoter.Config) any { return c.MaxRetryDuration }, 8 * s},
+		{"ping_count", "9", func(c kumaRepoter.Config) any { return c.PingCount }, 9},
+		{"ping_deadline_seconds", "11", func(c kumaRepoter.Config) any { return c.PingDeadline }, 11 * s},
+		{"ping_packet_timeout_ms", "250", func(c kumaRepoter.Config) any { return c.PingPacketTimeout }, 250 * ms},
+		{"ping_timeout_seconds", "12", func(c kumaRepoter.Config) any { return c.PingTimeout }, 12 * s},
+		{"trim_outliers", "true", func(c kumaRepoter.Config) any { return c.TrimOutliers }, true},
+		{"ping_interval_ms", "300", func(c kumaRepoter.Config) any { return c.PingInterval }, 300 * ms},
+		{"ping_packet_size", "1400", func(c kumaRepoter.Config) any { return c.PingPacketSize }, 1400},
+		{"http_timeout_seconds", "13", func(c kumaRepoter.Config) any { return c.HTTPTimeout }, 13 * s},
+		{"report_protocol", "healthchecks", func(c kumaRepoter.Config) any { return c.ReportProtocol }, "healthchecks"},
+		{"report_transport", "websocket", func(c kumaRepoter.Config) any { return c.ReportTransport }, "websocket"},
+		{"report_method", "POST", func(c kumaRepoter.Config) any { return c.ReportMethod }, "POST"},
+		{"report_body_template", "{{.Status}}", func(c kumaRepoter.Config) any { return c.ReportBodyTemplate }, "{{.Status}}"},
+		{"include_metadata", "true", func(c kumaRepoter.Config) any { return c.IncludeMetadata }, true},
+		{"source_name", "edge-1", func(c kumaRepoter.Config) any { return c.SourceName }, "edge-1"},
+		{"user_agent", "probe/2", func(c kumaRepoter.Config) any { return c.UserAgent }, "probe/2"},
+		{"report_ping_value", "false", func(c kumaRepoter.Config) any { return c.OmitPingValue }, true},
+		{"idempotency_key", "true", func(c kumaRepoter.Config) any { return c.IdempotencyKey }, true},
+		{"inject_traceparent", "true", func(c kumaRepoter.Config) any { return c.InjectTraceparent }, true},
+		{"correlation_id_header", "X-Request-Id", func(c kumaRepoter.Config) any { return c.CorrelationIDHeader }, "X-Request-Id"},
+		{"proxy_url", "http://proxy.example.com:3128", func(c kumaRepoter.Config) any { return c.ProxyURL }, "http://proxy.example.com:3128"},
+		{"dry_run", "true", func(c kumaRepoter.Config) any { return c.DryRun }, true},
+		{"tls_skip_verify", "true", func(c kumaRepoter.Config) any { return c.TLSSkipVerify }, true},
+		{"tls_ca_cert_file", "/etc/kuma/ca.pem", func(c kumaRepoter.Config) any { return c.TLSCACertFile }, "/etc/kuma/ca.pem"},
+		{"tls_client_cert", "/etc/kuma/client.pem", func(c kumaRepoter.Config) any { return c.TLSClientCert }, "/etc/kuma/client.pem"},
+		{"tls_client_key", "/etc/kuma/client.key", func(c kumaRepoter.Config) any { return c.TLSClientKey }, "/etc/kuma/client.key"},
+		{"status_message", "All good", func(c kumaRepoter.Config) any { return c.StatusMessage }, "All good"},
+		{"use_ipv4", "false", func(c kumaRepoter.Config) any { return c.UseIPv4 }, false},
+		{"use_ipv6", "true", func(c kumaRepoter.Config) any { return c.UseIPv6 }, true},
+		{"dual_stack", "true", func(c kumaRepoter.Config) any { return c.DualStack }, true},
+		{"use_system_ping", "true", func(c kumaRepoter.Config) any { return c.UseSystemPing }, true},
+		{"system_ping_path", "/opt/ping", func(c kumaRepoter.Config) any { return c.SystemPingPath }, "/opt/ping"},
+		{"system_ping6_path", "/opt/ping6", func(c kumaRepoter.Config) any { return c.SystemPing6Path }, "/opt/ping6"},
+		{"auto_fallback_ping", "true", func(c kumaRepoter.Config) any { return c.AutoFallbackPing }, true},
+		{"privileged_ping", "false", func(c kumaRepoter.Config) any { return c.PrivilegedPing }, false},
+		{"fallback_to_tcp", "true", func(c kumaRepoter.Config) any { return c.FallbackToTCP }, true},
+		{"fallback_tcp_port", "8443", func(c kumaRepoter.Config) any { return c.FallbackTCPPort }, 8443},
+		{"ping_all_ips", "true", func(c kumaRepoter.Config) any { return c.PingAllIPs }, true},
+		{"ping_all_ips_aggregate", "mean", func(c kumaRepoter.Config) any { return c.PingAllIPsAggregate }, "mean"},
+		{"ping_host_aggregate", "avg", func(c kumaRepoter.Config) any { return c.PingHostAggregate }, "avg"},
+		{"dns_server", "10.0.0.53", func(c kumaRepoter.Config) any { return c.DNSServer }, "10.0.0.53"},
+		{"dns_cache_ttl_seconds", "30", func(c kumaRepoter.Config) any { return c.DNSCacheTTL }, 30 * s},
+		{"use_stale_dns_on_error", "true", func(c kumaRepoter.Config) any { return c.UseStaleDNSOnError }, true},
+		{"check_mode", "tcp", func(c kumaRepoter.Config) any { return c.CheckMode }, "tcp"},
+		{"check_url", "https://app.example.com/health", func(c kumaRepoter.Config) any { return c.CheckURL }, "https://app.example.com/health"},
+		{"loss_down_threshold", "50.5", func(c kumaRepoter.Config) any { return c.LossDownThreshold }, 50.5},
+		{"flap_threshold", "3", func(c kumaRepoter.Config) any { return c.FlapThreshold }, 3},
+		{"smoothing_window", "5", func(c kumaRepoter.Config) any { return c.SmoothingWindow }, 5},
+		{"degraded_loss_threshold", "20", func(c kumaRepoter.Config) any { return c.DegradedLossThreshold }, 20.0},
+		{"simulated_rtts", "10,-1,20.5", func(c kumaRepoter.Config) any { return c.SimulatedRTTs }, []float64{10, -1, 20.5}},
+		{"log_level", "DEBUG", func(c kumaRepoter.Config) any { return c.LogLevel }, "DEBUG"},
+		{"log_format", "{level} {msg}", func(c kumaRepoter.Config) any { return c.LogFormat }, "{level} {msg}"},
+		{"log_caller", "true", func(c kumaRepoter.Config) any { return c.LogCaller }, true},
+		{"log_file", "/var/log/kuma.log", func(c kumaRepoter.Config) any { return c.LogFile }, "/var/log/kuma.log"},
+		{"log_max_size_mb", "50", func(c kumaRepoter.Config) any { return c.LogMaxSizeMB }, 50},
+		{"log_max_backups", "7", func(c kumaRepoter.Config) any { return c.LogMaxBackups }, 7},
+		{"max_concurrent_reports", "2", func(c kumaRepoter.Config) any { return c.MaxConcurrentReports }, 2},
+		{"min_report_gap_seconds", "15", func(c kumaRepoter.Config) any { return c.MinReportGap }, 15 * s},
+		{"max_reports_per_minute", "30", func(c kumaRepoter.Config) any { return c.MaxReportsPerMinute }, 30},
+		{"circuit_breaker_threshold", "4", func(c kumaRepoter.Config) any { return c.CircuitBreakerThreshold }, 4},
+		{"circuit_probe_interval_seconds", "90", func(c kumaRepoter.Config) any { return c.CircuitProbeInterval }, 90 * s},
+		{"detect_clock_jumps", "true", func(c kumaRepoter.Config) any { return c.DetectClockJumps }, true},
+		{"shutdown_timeout_seconds", "25", func(c kumaRepoter.Config) any { return c.ShutdownTimeout }, 25 * s},
+		{"state_file_path", "/var/lib/kuma/state.json", func(c kumaRepoter.Config) any { return c.StateFilePath }, "/var/lib/kuma/state.json"},
+		{"report_down_on_shutdown", "true", func(c kumaRepoter.Config) any { return c.ReportDownOnShutdown }, true},
+		{"shutdown_message", "Maintenance", func(c kumaRepoter.Config) any { return c.ShutdownMessage }, "Maintenance"},
+		{"metrics_listen_addr", ":9100", func(c kumaRepoter.Config) any { return c.MetricsListenAddr }, ":9100"},
+		{"health_listen_addr", ":8081", func(c kumaRepoter.Config) any { return c.HealthListenAddr }, ":8081"},
+		{"otlp_endpoint", "http://otel.example.com:4318", func(c kumaRepoter.Config) any { return c.OTLPEndpoint }, "http://otel.example.com:4318"},
+	}
+}
+
+func TestEnvMapping(t *testing.T) {
+	for _, tt := range envCases() {
+		env := "UPTIME_" + strings.ToUpper(tt.key)
+		t.Run(env, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			t.Setenv(env, tt.value)
+			viper.Reset()
+			defer viper.Reset()
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.got(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s=%q gives %v, want %v", env, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEnvMappingCoversReadme keeps envCases in step with the environment
+// variable table of the README.
+func TestEnvMappingCoversReadme(t *testing.T) {
+	readme, err := os.ReadFile("../../README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := regexp.MustCompile("(?m)^\\| `([a-z0-9_]+)` \\| `UPTIME_([A-Z0-9_]+)`")
+	var documented []string
+	for _, match := range row.FindAllStringSubmatch(string(readme), -1) {
+		if strings.ToUpper(match[1]) != match[2] {
+			t.Errorf("README maps %s to UPTIME_%s", match[1], match[2])
+		}
+		documented = append(documented, match[1])
+	}
+
+	var tested []string
+	for _, tt := range envCases() {
+		tested = append(tested, tt.key)
+	}
+	slices.Sort(documented)
+	slices.Sort(tested)
+	if !slices.Equal(documented, tested) {
+		t.Errorf("README documents %v, the test covers %v", documented, tested)
+	}
+}
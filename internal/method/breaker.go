@@ -0,0 +1,86 @@
+package method
+
+import (
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"sync"
+	"time"
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops sending to an endpoint after CircuitBreakerThreshold
+// consecutive failures. While open, one probe is let through every
+// CircuitProbeInterval, closing the circuit again when it succeeds.
+type circuitBreaker struct {
+	cfg model.Config
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// allow returns a terminal error while the circuit is open and no probe is due.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if open := clockOf(b.cfg).Now().Sub(b.openedAt); open < b.cfg.CircuitProbeInterval {
+			return terminal(fmt.Errorf("circuit open after %d failed reports, next probe in %s",
+				b.failures, (b.cfg.CircuitProbeInterval - open).Round(time.Second)))
+		}
+		b.transition(circuitHalfOpen)
+	case circuitHalfOpen:
+		// A probe is already on its way
+		return terminal(fmt.Errorf("circuit half-open, waiting for the probe"))
+	}
+
+	return nil
+}
+
+// record updates the circuit with the outcome of an allowed report.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		if b.state != circuitClosed {
+			b.transition(circuitClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.cfg.CircuitBreakerThreshold {
+		b.openedAt = clockOf(b.cfg).Now()
+		if b.state != circuitOpen {
+			b.transition(circuitOpen)
+		}
+	}
+}
+
+func (b *circuitBreaker) transition(to circuitState) {
+	b.cfg.Logger("WARN", "Report circuit ", b.state, " -> ", to)
+	b.state = to
+}
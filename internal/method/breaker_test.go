@@ -0,0 +1,74 @@
+package method
+
+import (
+	"context"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var status, requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	clock := NewFakeClock(testEpoch)
+	logs := &logRecorder{}
+	cfg := testConfig(srv.URL)
+	cfg.Clock = clock
+	cfg.Logger = logs.log
+	cfg.CircuitBreakerThreshold = 2
+	cfg.CircuitProbeInterval = time.Minute
+	reporter, err := NewHTTPReporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	breaker := reporter.(*httpReporter).breaker
+
+	// One report per step, in order
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		status    int
+		wantSent  bool
+		wantState circuitState
+	}{
+		{name: "first failure", status: http.StatusBadGateway, wantSent: true, wantState: circuitClosed},
+		{name: "threshold", status: http.StatusBadGateway, wantSent: true, wantState: circuitOpen},
+		{name: "open", status: http.StatusOK, wantState: circuitOpen},
+		{name: "before the probe", advance: 59 * time.Second, status: http.StatusOK, wantState: circuitOpen},
+		{name: "failed probe", advance: time.Second, status: http.StatusBadGateway, wantSent: true, wantState: circuitOpen},
+		{name: "probe interval restarts", advance: 30 * time.Second, status: http.StatusOK, wantState: circuitOpen},
+		{name: "probe", advance: 30 * time.Second, status: http.StatusOK, wantSent: true, wantState: circuitClosed},
+		{name: "failures start over", status: http.StatusBadGateway, wantSent: true, wantState: circuitClosed},
+	}
+
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		status.Store(int64(step.status))
+		before := requests.Load()
+
+		err := reporter.Report(context.Background(), model.Heartbeat{Status: statusUp, Msg: "OK"})
+		if sent := requests.Load() > before; sent != step.wantSent {
+			t.Errorf("%s: sent %t, want %t (err %v)", step.name, sent, step.wantSent, err)
+		}
+		if !step.wantSent && (err == nil || isRetryable(err)) {
+			t.Errorf("%s: skipped report returned %v, want a terminal error", step.name, err)
+		}
+		if breaker.state != step.wantState {
+			t.Errorf("%s: circuit %s, want %s", step.name, breaker.state, step.wantState)
+		}
+	}
+
+	for _, transition := range []string{"closed -> open", "open -> half-open", "half-open -> open", "half-open -> closed"} {
+		if !logs.contains("WARN", transition) {
+			t.Errorf("transition %q not logged", transition)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package method
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// newReportClient builds the HTTP client used to push reports. It is created
+// once per target and reused, so pushes share keep-alive connections.
+func newReportClient(cfg model.Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// One push per period, keep the connection idle long enough to reuse it
+	transport.MaxIdleConnsPerHost = 2
+	transport.IdleConnTimeout = max(transport.IdleConnTimeout, 2*cfg.ReportPeriod)
+
+	transport.Proxy = http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if socket, _, ok := splitUnixSocketURL(cfg.ReportURL); ok {
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Timeout:   cfg.HTTPTimeout,
+		Transport: transport,
+	}, nil
+}
+
+// splitUnixSocketURL splits "unix:///path/to.sock:/api/push/token" into the
+// socket path and the HTTP URL requested over it.
+func splitUnixSocketURL(raw string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(raw, "unix://")
+	if !ok {
+		return "", "", false
+	}
+
+	socket, requestPath, _ := strings.Cut(rest, ":")
+	if !strings.HasPrefix(requestPath, "/") {
+		requestPath = "/" + requestPath
+	}
+	return socket, "http://unix" + requestPath, true
+}
+
+func newTLSConfig(cfg model.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		// Insecure: only meant for self-signed instances that cannot use TLSCACertFile
+		InsecureSkipVerify: cfg.TLSSkipVerify,
+	}
+
+	if cfg.TLSCACertFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA cert: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.TLSClientCert != "" || cfg.TLSClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSClientCert, cfg.TLSClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client cert: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
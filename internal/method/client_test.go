@@ -0,0 +1,136 @@
+package method
+
+import (
+	"context"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportThroughProxy(t *testing.T) {
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		name     string
+		proxyURL string
+		wantErr  string
+	}{
+		{name: "http proxy", proxyURL: proxy.URL},
+		{name: "unsupported scheme", proxyURL: "ftp://proxy.example.com", wantErr: "unsupported proxy scheme"},
+		{name: "invalid url", proxyURL: "http://proxy example.com", wantErr: "invalid proxy URL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("http://kuma.invalid")
+			cfg.ProxyURL = tt.proxyURL
+
+			client, err := newReportClient(cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newReportClient() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hb := model.Heartbeat{Status: statusUp, Msg: "OK"}
+			if err := sendReport(context.Background(), cfg, client, hb); err != nil {
+				t.Fatal(err)
+			}
+			if got := <-proxied; !strings.HasPrefix(got, "http://kuma.invalid/api/push/test?") {
+				t.Errorf("proxy got %q, want the report URL", got)
+			}
+		})
+	}
+}
+
+func TestSplitUnixSocketURL(t *testing.T) {
+	tests := []struct {
+		raw        string
+		wantSocket string
+		wantURL    string
+		wantOK     bool
+	}{
+		{raw: "unix:///run/kuma.sock:/api/push/token", wantSocket: "/run/kuma.sock", wantURL: "http://unix/api/push/token", wantOK: true},
+		{raw: "unix:///run/kuma.sock:api/push/token", wantSocket: "/run/kuma.sock", wantURL: "http://unix/api/push/token", wantOK: true},
+		{raw: "unix:///run/kuma.sock", wantSocket: "/run/kuma.sock", wantURL: "http://unix/", wantOK: true},
+		{raw: "https://kuma.example.com/api/push/token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			socket, httpURL, ok := splitUnixSocketURL(tt.raw)
+			if socket != tt.wantSocket || httpURL != tt.wantURL || ok != tt.wantOK {
+				t.Errorf("splitUnixSocketURL() = %q, %q, %t, want %q, %q, %t", socket, httpURL, ok, tt.wantSocket, tt.wantURL, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestReportOverUnixSocket(t *testing.T) {
+	// Not t.TempDir, unix socket paths are limited to about 100 bytes
+	dir, err := os.MkdirTemp("", "kuma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "push.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requested := make(chan string, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- r.URL.Path
+	}))
+	srv.Listener = listener
+	srv.Start()
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		reportURL string
+		wantErr   bool
+	}{
+		{name: "socket", reportURL: "unix://" + socket + ":"},
+		{name: "missing socket", reportURL: "unix://" + filepath.Join(dir, "missing.sock") + ":", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The proxy must never be used for a socket
+			t.Setenv("HTTP_PROXY", "http://proxy.invalid")
+			reporter, err := NewHTTPReporter(testConfig(tt.reportURL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = reporter.Report(context.Background(), model.Heartbeat{Status: statusUp, Msg: "OK"})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Report() = nil, want a dial error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := <-requested; got != "/api/push/test" {
+				t.Errorf("requested %q, want /api/push/test", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package method
+
+import (
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"slices"
+	"sync"
+	"time"
+)
+
+// clockOf returns the configured clock, the real one when unset.
+func clockOf(cfg model.Config) model.Clock {
+	if cfg.Clock == nil {
+		return realClock{}
+	}
+
+	return cfg.Clock
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) model.Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// FakeClock is a Clock that only moves on Advance, for tests of the report
+// loop. Timers and tickers fire from Advance once their time is reached.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is a pending After (period zero) or ticker.
+type fakeWaiter struct {
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+// NewFakeClock returns a FakeClock starting at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).ch
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) model.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+
+	return &fakeTicker{clock: c, waiter: c.add(d, d)}
+}
+
+func (c *FakeClock) add(d, period time.Duration) *fakeWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := &fakeWaiter{at: c.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	c.fire()
+
+	return w
+}
+
+// Advance moves the clock forward by d and fires everything due. Like a
+// real ticker, a ticker that fell behind delivers a single tick.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+// Jump moves the wall clock by d without firing anything, like a suspend or
+// an NTP step that real timers do not notice either.
+func (c *FakeClock) Jump(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, w := range c.waiters {
+		w.at = w.at.Add(d)
+	}
+}
+
+// Waiters returns the number of pending timers and tickers, so a test can
+// wait until the code under test is blocked on the clock.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
+
+func (c *FakeClock) fire() {
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !w.at.After(c.now) {
+			select {
+			case w.ch <- c.now:
+			default:
+			}
+			if w.period == 0 {
+				continue
+			}
+			for !w.at.After(c.now) {
+				w.at = w.at.Add(w.period)
+			}
+		}
+		pending = append(pending, w)
+	}
+	clear(c.waiters[len(pending):])
+	c.waiters = pending
+}
+
+type fakeTicker struct {
+	clock  *FakeClock
+	waiter *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.waiter.ch
+}
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	if !slices.Contains(t.clock.waiters, t.waiter) {
+		t.clock.waiters = append(t.clock.waiters, t.waiter)
+	}
+	t.waiter.period = d
+	t.waiter.at = t.clock.now.Add(d)
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	t.clock.waiters = slices.DeleteFunc(t.clock.waiters, func(w *fakeWaiter) bool {
+		return w == t.waiter
+	})
+}
@@ -0,0 +1,165 @@
+package method
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeClockTicker(t *testing.T) {
+	tests := []struct {
+		name     string
+		advances []time.Duration
+		want     int
+	}{
+		{name: "before the period", advances: []time.Duration{59 * time.Second}, want: 0},
+		{name: "each period", advances: []time.Duration{time.Minute, time.Minute, time.Minute}, want: 3},
+		{name: "fell behind ticks once", advances: []time.Duration{5 * time.Minute}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testEpoch)
+			ticker := clock.NewTicker(time.Minute)
+			defer ticker.Stop()
+
+			var got int
+			for _, d := range tt.advances {
+				clock.Advance(d)
+				select {
+				case <-ticker.C():
+					got++
+				default:
+				}
+			}
+			if got != tt.want {
+				t.Errorf("got %d ticks, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeClockJump(t *testing.T) {
+	clock := NewFakeClock(testEpoch)
+	after := clock.After(time.Minute)
+
+	clock.Jump(time.Hour)
+	select {
+	case <-after:
+		t.Fatal("timer fired on a jump")
+	default:
+	}
+	if got := clock.Now(); !got.Equal(testEpoch.Add(time.Hour)) {
+		t.Errorf("Now() = %s after the jump", got)
+	}
+
+	clock.Advance(time.Minute)
+	select {
+	case <-after:
+	default:
+		t.Fatal("timer did not fire a minute after the jump")
+	}
+}
+
+func TestClockJump(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{name: "on time", elapsed: clockCheckInterval, want: 0},
+		{name: "late tick", elapsed: clockCheckInterval + 100*time.Millisecond, want: 100 * time.Millisecond},
+		{name: "suspend", elapsed: clockCheckInterval + time.Hour, want: time.Hour},
+		{name: "stepped back", elapsed: clockCheckInterval - time.Minute, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clockJump(testEpoch, testEpoch.Add(tt.elapsed)); got != tt.want {
+				t.Errorf("clockJump() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	tests := []struct {
+		name    string
+		drain   int
+		advance time.Duration
+		want    int
+	}{
+		{name: "empty", drain: 6, advance: 0, want: 0},
+		{name: "one token per ten seconds", drain: 6, advance: 20 * time.Second, want: 2},
+		{name: "capped at the capacity", drain: 6, advance: time.Hour, want: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testEpoch)
+			bucket := newTokenBucket(clock, 6)
+			for range tt.drain {
+				bucket.allow()
+			}
+
+			clock.Advance(tt.advance)
+			var got int
+			for bucket.allow() {
+				got++
+			}
+			if got != tt.want {
+				t.Errorf("got %d tokens, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunTargetClockJump(t *testing.T) {
+	var sent atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		sent.Add(1)
+	}))
+	defer srv.Close()
+
+	clock := NewFakeClock(testEpoch)
+	cfg := testConfig(srv.URL)
+	cfg.Clock = clock
+	cfg.DetectClockJumps = true
+
+	stop := make(chan struct{})
+	reports := &inFlight{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runTarget(context.Background(), context.Background(), stop, cfg, reports, &targetStates{}, "test", "test")
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// The period and clock check tickers
+	waitFor(t, func() bool { return sent.Load() == 1 && reports.Pending() == 0 && clock.Waiters() == 2 })
+
+	clock.Jump(10 * time.Minute)
+	clock.Advance(clockCheckInterval)
+	waitFor(t, func() bool { return sent.Load() == 2 })
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -2,38 +2,594 @@ package method
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var Logger func(string, ...any)
 
+// ErrGracefulShutdown, given as the cause when cancelling the daemon's
+// context with context.WithCancelCause, drains instead of aborting: every
+// target sends a final report and reports in flight may finish within
+// ShutdownTimeout. Any other cancellation aborts the reports right away.
+var ErrGracefulShutdown = errors.New("graceful shutdown")
+
+// ErrPingFailed and ErrReportFailed tell why RunOnce failed: a target was
+// reported down, or its report could not be delivered.
+var (
+	ErrPingFailed   = errors.New("ping failed")
+	ErrReportFailed = errors.New("report failed")
+)
+
 func Daemon(ctx context.Context, cfg model.Config) {
+	DaemonWithReload(ctx, cfg, nil)
+}
+
+// DaemonWithReload runs like Daemon and restarts the target loops with every
+// config received on reload. Logger, LogLevel, LogFormat, the log file,
+// MetricsListenAddr, HealthListenAddr, OTLPEndpoint and ShutdownTimeout are
+// fixed at startup and ignored on reload. Targets kept by a reload resume
+// their state and schedule without an initial report.
+func DaemonWithReload(ctx context.Context, cfg model.Config, reload <-chan model.Config) {
+	setupLogger(cfg)
+
+	cfg = clampPingCount(cfg)
+	if err := cfg.Validate(); err != nil {
+		Logger("FATAL", "Invalid configuration: ", err)
+		return
+	}
+
+	stopTelemetry := startTelemetry(ctx, cfg)
+	defer stopTelemetry()
+
+	setReportRateLimit(cfg)
+	daemonAlive.Store(true)
+	defer daemonAlive.Store(false)
+
+	var wg sync.WaitGroup
+	startServers(ctx, cfg, &wg)
+
+	// Reports outlive ctx during a graceful shutdown
+	reportCtx, cancelReports := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelReports()
+
+	reports := &inFlight{}
+	states := &targetStates{}
+	stopTargets := startTargets(ctx, reportCtx, cfg, reports, states)
+
+loop:
+	for {
+		select {
+		case next := <-reload:
+			next = clampPingCount(next)
+			if err := next.Validate(); err != nil {
+				Logger("ERROR", "Ignoring invalid configuration reload: ", err)
+				continue
+			}
+			next.Logger = cfg.Logger
+			next.Quiet = cfg.Quiet
+			next.LogLevel = cfg.LogLevel
+			next.LogFile = cfg.LogFile
+			next.LogFormat = cfg.LogFormat
+			next.LogCaller = cfg.LogCaller
+			next.LogMaxSizeMB = cfg.LogMaxSizeMB
+			next.LogMaxBackups = cfg.LogMaxBackups
+			next.MetricsListenAddr = cfg.MetricsListenAddr
+			next.HealthListenAddr = cfg.HealthListenAddr
+			next.ShutdownTimeout = cfg.ShutdownTimeout
+			if next.Clock == nil {
+				next.Clock = cfg.Clock
+			}
+			next.OTLPEndpoint = cfg.OTLPEndpoint
+
+			stopTargets()
+			if next.MaxReportsPerMinute != cfg.MaxReportsPerMinute {
+				setReportRateLimit(next)
+			}
+			cfg = next
+			stopTargets = startTargets(ctx, reportCtx, cfg, reports, states)
+			Logger("INFO", "Configuration reloaded")
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	if !errors.Is(context.Cause(ctx), ErrGracefulShutdown) {
+		cancelReports()
+	}
+	stopTargets()
+	wg.Wait()
+
+	if pending := reports.Pending(); pending > 0 && cfg.ShutdownTimeout > 0 {
+		Logger("INFO", "Waiting for ", pending, " in-flight reports")
+		if !reports.Wait(clockOf(cfg), cfg.ShutdownTimeout) {
+			Logger("WARN", "Shutdown timeout reached with ", reports.Pending(), " reports still pending")
+		}
+	}
+
+	Logger("INFO", "Service stopped")
+}
+
+// RunOnce runs one report cycle for every target and returns, for cron jobs
+// and scripts. The error wraps ErrPingFailed when a target was reported down
+// and otherwise ErrReportFailed when a report could not be delivered.
+// OpenTelemetry and the metrics and health servers are not started.
+func RunOnce(ctx context.Context, cfg model.Config) error {
+	setupLogger(cfg)
+
+	cfg = clampPingCount(cfg)
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	setReportRateLimit(cfg)
+
+	targets := resolveTargets(cfg)
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = runTargetOnce(ctx, targetConfig(cfg, target), targetName(target))
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func runTargetOnce(ctx context.Context, cfg model.Config, name string) error {
+	sinks, err := reportSinks(cfg)
+	if err != nil {
+		return fmt.Errorf("cannot create reporter: %w", err)
+	}
+	if len(sinks) == 0 {
+		return errors.New("no report URL or reporter configured")
+	}
+	defer func() {
+		for _, sink := range sinks {
+			if closer, ok := sink.(io.Closer); ok {
+				_ = closer.Close()
+			}
+		}
+	}()
+
+	rt := newTargetRuntime(cfg, sinks, nil)
+	rt.name = name
+	res, err := reportWithRetry(ctx, cfg, rt)
+	if err == nil {
+		return nil
+	}
+	cfg.Logger("ERROR", "Report failed: ", err)
+	if res.Status == statusDown {
+		return fmt.Errorf("%w: %w", ErrPingFailed, err)
+	}
+	return fmt.Errorf("%w: %w", ErrReportFailed, err)
+}
+
+// setupLogger points the package Logger at the output configured by cfg.
+func setupLogger(cfg model.Config) {
 	Logger = DefaultLogger
-	if cfg.Logger != nil {
+	format := defaultLogFormat
+	if cfg.LogFormat != "" && cfg.Logger == nil && !cfg.Quiet {
+		if parsed, err := parseLogFormat(cfg.LogFormat); err != nil {
+			Logger("ERROR", "Invalid log format, using the default: ", err)
+		} else {
+			format = parsed
+			Logger = format.stdout
+		}
+	}
+	if cfg.Quiet {
+		Logger = NopLogger
+	} else if cfg.Logger != nil {
 		Logger = cfg.Logger
+	} else if cfg.LogFile != "" {
+		fileLogger, err := newFileLogger(cfg.LogFile, cfg.LogMaxSizeMB, cfg.LogMaxBackups, format)
+		if err != nil {
+			Logger("ERROR", "Cannot open log file, logging to stdout: ", err)
+		} else {
+			Logger = fileLogger
+		}
+	}
+	if cfg.LogCaller {
+		Logger = withCaller(Logger)
 	}
+	Logger = levelFilter(cfg.LogLevel, Logger)
+}
 
-	go func() {
-		if err := reportWithRetry(ctx, cfg); err != nil {
-			Logger("Initial report failed: %v", err)
-		}
+// clampPingCount raises a PingCount below 1, which would make the built-in
+// ping wait forever and the system ping reject "-c 0", to 1.
+func clampPingCount(cfg model.Config) model.Config {
+	if cfg.PingCount < 1 {
+		Logger("WARN", "Ping count ", cfg.PingCount, " is below 1, using 1")
+		cfg.PingCount = 1
+	}
+
+	return cfg
+}
+
+// startTargets launches one report loop per target and returns a function
+// that stops the loops. The loops end with ctx, their reports run under
+// reportCtx and keep running when the loops stop. A target already in
+// states resumes where its previous loop stopped.
+func startTargets(ctx, reportCtx context.Context, cfg model.Config, reports *inFlight, states *targetStates) func() {
+	stop := make(chan struct{})
+
+	targets := resolveTargets(cfg)
+	keys := make([]string, len(targets))
+	names := make([]string, len(targets))
+	for i, target := range targets {
+		keys[i] = targetKey(target)
+		names[i] = targetName(target)
+	}
+	states.retain(keys)
+	retainState(cfg, names)
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(c model.Config, key, name string) {
+			defer wg.Done()
+			runTarget(ctx, reportCtx, stop, c, reports, states, key, name)
+		}(targetConfig(cfg, target), keys[i], names[i])
+	}
+
+	return func() {
+		close(stop)
+		wg.Wait()
+	}
+}
+
+// targetStates keeps the runtime of every running target by targetKey, so
+// a reload does not reset the flap count, smoothing window, circuit breaker
+// and failover of the targets it keeps.
+type targetStates struct {
+	mu       sync.Mutex
+	runtimes map[string]*targetRuntime
+}
+
+func (s *targetStates) load(key string) *targetRuntime {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.runtimes[key]
+}
+
+func (s *targetStates) store(key string, rt *targetRuntime) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.runtimes == nil {
+		s.runtimes = map[string]*targetRuntime{}
+	}
+	s.runtimes[key] = rt
+}
+
+// retain forgets the targets a reload removed.
+func (s *targetStates) retain(keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	maps.DeleteFunc(s.runtimes, func(key string, _ *targetRuntime) bool {
+		return !slices.Contains(keys, key)
+	})
+}
+
+// resolveTargets returns the configured targets, falling back to the
+// single PingHost/ReportURL pair when no target list is given.
+func resolveTargets(cfg model.Config) []model.MonitorTarget {
+	if len(cfg.Targets) > 0 {
+		return cfg.Targets
+	}
+
+	return []model.MonitorTarget{{
+		Host:          cfg.PingHost,
+		ReportURL:     cfg.ReportURL,
+		StatusMessage: cfg.StatusMessage,
+	}}
+}
+
+// targetKey identifies a target across reloads.
+func targetKey(target model.MonitorTarget) string {
+	return target.Name + "\x00" + target.Host + "\x00" + model.NormalizeReportURL(target.ReportURL)
+}
+
+// targetName names a target in logs and the state file, by its Name or
+// else its host.
+func targetName(target model.MonitorTarget) string {
+	if target.Name != "" {
+		return target.Name
+	}
+
+	return target.Host
+}
+
+// targetConfig derives the config used by a single target's report loop.
+func targetConfig(cfg model.Config, target model.MonitorTarget) model.Config {
+	cfg.PingHost = target.Host
+	cfg.ReportURL = model.NormalizeReportURL(target.ReportURL)
+	if target.StatusMessage != "" {
+		cfg.StatusMessage = target.StatusMessage
+	}
+	if target.CheckURL != "" {
+		cfg.CheckURL = target.CheckURL
+	}
+	if target.ReportPeriod > 0 {
+		cfg.ReportPeriod = target.ReportPeriod
+	}
+	if target.MaxRetries > 0 {
+		cfg.MaxRetries = target.MaxRetries
+	}
+	if target.RetryDelay > 0 {
+		cfg.RetryDelay = target.RetryDelay
+	}
+	if len(cfg.Targets) > 0 {
+		// The failover URLs belong to the single ReportURL
+		cfg.ReportURLs = nil
+	}
+	cfg.Targets = nil
+	// Filter before prefixing, so dropped lines cost no tagging
+	cfg.Logger = levelFilter(cfg.LogLevel, prefixLogger(targetName(target), Logger))
+	if cfg.PingProvider == nil && len(cfg.SimulatedRTTs) > 0 {
+		// Every target replays the sequence from the start
+		cfg.PingProvider = NewSimulatedPingProvider(cfg.SimulatedRTTs)
+	}
+
+	return cfg
+}
+
+func runTarget(ctx, reportCtx context.Context, stop <-chan struct{}, cfg model.Config, reports *inFlight, states *targetStates, key, name string) {
+	sinks, err := reportSinks(cfg)
+	if err != nil {
+		cfg.Logger("ERROR", "Cannot create reporter: ", err)
+		return
+	}
+	if len(sinks) == 0 {
+		cfg.Logger("ERROR", "No report URL or reporter configured")
+		return
+	}
+
+	// Persistent transports hold a connection until the target stopped and
+	// its last report, e.g. the final one of a graceful shutdown, is done
+	var running sync.WaitGroup
+	defer func() {
+		go func() {
+			running.Wait()
+			for _, sink := range sinks {
+				if closer, ok := sink.(io.Closer); ok {
+					_ = closer.Close()
+				}
+			}
+		}()
 	}()
 
-	ticker := time.NewTicker(cfg.ReportPeriod)
+	clock := clockOf(cfg)
+	prev := states.load(key)
+	rt := newTargetRuntime(cfg, sinks, prev)
+	rt.name = name
+	states.store(key, rt)
+
+	// sem bounds the report goroutines of this target, so slow retries
+	// under a short period skip ticks instead of piling up
+	sem := make(chan struct{}, max(cfg.MaxConcurrentReports, 1))
+	// cycle runs one report holding a sem slot and releases it
+	cycle := func(failure string, done func()) {
+		defer func() {
+			rt.lastFinished.Store(clock.Now().UnixNano())
+			<-sem
+		}()
+		if done != nil {
+			defer done()
+		}
+		res, err := reportWithRetry(reportCtx, cfg, rt)
+		if err != nil {
+			cfg.Logger("ERROR", failure, err)
+			return
+		}
+		cfg.Logger("DEBUG", "Cycle done: ", res.Status, " via ", orDefault(res.Result.IP, "-"),
+			" after ", res.Attempts, " attempt(s), ", millis(res.Result.AvgRttMs))
+	}
+	launch := func(failure string, done func()) {
+		select {
+		case sem <- struct{}{}:
+		default:
+			cfg.Logger("WARN", "Previous report still running, skipping this tick")
+			return
+		}
+
+		running.Add(1)
+		reports.Go(func() {
+			defer running.Done()
+			cycle(failure, done)
+		})
+	}
+
+	// After a reload the target already reported, it waits for the next tick
+	if delay := startupDelay(cfg); delay > 0 && prev == nil {
+		cfg.Logger("INFO", "Waiting ", delay.Round(time.Millisecond), " before the first report")
+		select {
+		case <-clock.After(delay):
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	// A graceful shutdown sends one more report, whichever case sees it first
+	finish := func() {
+		if !errors.Is(context.Cause(ctx), ErrGracefulShutdown) {
+			return
+		}
+		if cfg.ReportDownOnShutdown {
+			reportShutdown(reportCtx, cfg, sinks, &running)
+			return
+		}
+
+		// Unlike a tick, the final report waits for a running one, bounded
+		// by ShutdownTimeout
+		running.Add(1)
+		reports.Go(func() {
+			defer running.Done()
+			select {
+			case sem <- struct{}{}:
+			default:
+				cfg.Logger("DEBUG", "Waiting for the running report before the final one")
+				select {
+				case sem <- struct{}{}:
+				case <-clock.After(cfg.ShutdownTimeout):
+					cfg.Logger("WARN", "Report still running after ", cfg.ShutdownTimeout, ", skipping the final report")
+					return
+				}
+			}
+			cycle("Final report failed: ", nil)
+		})
+	}
+
+	// The first tick is skipped while the initial report is still running or
+	// finished less than half a period ago, so the two do not overlap
+	var initialFinished atomic.Int64
+	firstTick := prev == nil
+	if firstTick {
+		launch("Initial report failed: ", func() {
+			initialFinished.Store(clock.Now().UnixNano())
+		})
+	}
+
+	// The period ticker is reset after every tick to apply a fresh jitter
+	ticker := clock.NewTicker(nextInterval(cfg))
 	defer ticker.Stop()
 
+	// Timers follow the monotonic clock, which stands still while the machine
+	// sleeps. The wall clock does not, so more wall time than the check
+	// interval between two checks reveals a suspend.
+	var clockCheck <-chan time.Time
+	lastCheck := clock.Now().Round(0)
+	if cfg.DetectClockJumps {
+		checkTicker := clock.NewTicker(clockCheckInterval)
+		defer checkTicker.Stop()
+		clockCheck = checkTicker.C()
+	}
+
 	for {
 		select {
-		case <-ticker.C:
-			go func(c model.Config) {
-				if err := reportWithRetry(ctx, c); err != nil {
-					Logger("Periodic report failure: %v", err)
+		case <-clockCheck:
+			now := clock.Now().Round(0)
+			jump := clockJump(lastCheck, now)
+			lastCheck = now
+			if jump < clockJumpThreshold {
+				continue
+			}
+			cfg.Logger("WARN", "Clock jumped by ", jump.Round(time.Second), ", probably after a suspend")
+			if jump >= cfg.ReportPeriod {
+				launch("Catch-up report failed: ", nil)
+				ticker.Reset(nextInterval(cfg))
+			}
+		case <-ticker.C():
+			ticker.Reset(nextInterval(cfg))
+			if firstTick {
+				firstTick = false
+				finished := initialFinished.Load()
+				if finished == 0 || clock.Now().Sub(time.Unix(0, finished)) < cfg.ReportPeriod/2 {
+					cfg.Logger("DEBUG", "Initial report still running or just finished, skipping first tick")
+					continue
 				}
-			}(cfg)
+			}
+			// A cycle that retried until just now would double the load
+			if finished := rt.lastFinished.Load(); cfg.MinReportGap > 0 && finished != 0 &&
+				clock.Now().Sub(time.Unix(0, finished)) < cfg.MinReportGap {
+				cfg.Logger("INFO", "Previous report finished less than ", cfg.MinReportGap, " ago, skipping this tick")
+				continue
+			}
+			launch("Periodic report failure: ", nil)
+		case <-stop:
+			finish()
+			return
 		case <-ctx.Done():
-			Logger("Service stopped")
+			finish()
 			return
 		}
 	}
 }
+
+// reportShutdown pushes the ReportDownOnShutdown beat to every sink. It
+// first waits for the target's running cycles, so an up beat cannot land
+// after it. Waiting and sending are each bounded by shutdownReportTimeout.
+func reportShutdown(ctx context.Context, cfg model.Config, sinks []model.Reporter, running *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-clockOf(cfg).After(shutdownReportTimeout):
+		cfg.Logger("WARN", "Report still running, sending the shutdown report anyway")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, shutdownReportTimeout)
+	defer cancel()
+
+	hb := model.Heartbeat{
+		ID:     randomHex(16),
+		Host:   cfg.PingHost,
+		Status: statusDown,
+		Msg:    orDefault(cfg.ShutdownMessage, defaultShutdownMessage),
+	}
+	if err := sinks[0].Report(ctx, hb); err != nil {
+		cfg.Logger("ERROR", "Shutdown report failed: ", err)
+	} else {
+		cfg.Logger("INFO", "Reported down for shutdown")
+	}
+	reportSecondary(ctx, cfg, sinks[1:], hb)
+}
+
+const (
+	shutdownReportTimeout  = 5 * time.Second
+	defaultShutdownMessage = "Reporter stopped"
+)
+
+const (
+	clockCheckInterval = 5 * time.Second
+	clockJumpThreshold = 10 * time.Second
+)
+
+// clockJump returns how far the wall clock moved between two clock checks
+// beyond the check interval, in either direction.
+func clockJump(last, now time.Time) time.Duration {
+	jump := now.Sub(last) - clockCheckInterval
+	if jump < 0 {
+		return -jump
+	}
+
+	return jump
+}
+
+// startupDelay is StartupDelay plus a uniform random part of up to
+// StartupDelayJitter, spreading the first reports of a rolling deploy.
+func startupDelay(cfg model.Config) time.Duration {
+	delay := cfg.StartupDelay
+	if cfg.StartupDelayJitter > 0 {
+		delay += rand.N(cfg.StartupDelayJitter + 1)
+	}
+
+	return delay
+}
+
+// nextInterval returns ReportPeriod shifted by a uniform random offset within
+// ±ReportPeriodJitter, so the average period stays ReportPeriod.
+func nextInterval(cfg model.Config) time.Duration {
+	if cfg.ReportPeriodJitter <= 0 {
+		return cfg.ReportPeriod
+	}
+
+	offset := rand.Int64N(2*int64(cfg.ReportPeriodJitter)+1) - int64(cfg.ReportPeriodJitter)
+	return cfg.ReportPeriod + time.Duration(offset)
+}
@@ -0,0 +1,672 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Target loggers wrap the daemon's
+	Logger = NopLogger
+	os.Exit(m.Run())
+}
+
+func TestNewTargetRuntimeCarriesState(t *testing.T) {
+	tests := []struct {
+		name        string
+		reportURL   string
+		window      int
+		wantCircuit circuitState
+		wantWindow  bool
+	}{
+		{name: "same target", reportURL: "https://kuma.example.com/api/push/a", window: 5, wantCircuit: circuitOpen, wantWindow: true},
+		{name: "resized window", reportURL: "https://kuma.example.com/api/push/a", window: 3, wantCircuit: circuitOpen, wantWindow: false},
+		{name: "new report URL", reportURL: "https://kuma.example.com/api/push/b", window: 5, wantCircuit: circuitClosed, wantWindow: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.ReportURL = "https://kuma.example.com/api/push/a"
+			cfg.CircuitBreakerThreshold = 1
+			cfg.CircuitProbeInterval = time.Minute
+			cfg.SmoothingWindow = 5
+
+			sinks, err := reportSinks(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prev := newTargetRuntime(cfg, sinks, nil)
+			prev.badCycles.Store(2)
+			prev.sinks[0].(*httpReporter).breaker.record(errNoResponse)
+
+			cfg.ReportURL = tt.reportURL
+			cfg.SmoothingWindow = tt.window
+			sinks, err = reportSinks(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rt := newTargetRuntime(cfg, sinks, prev)
+
+			if got := rt.badCycles.Load(); got != 2 {
+				t.Errorf("badCycles = %d, want 2", got)
+			}
+			if got := rt.sinks[0].(*httpReporter).breaker.state; got != tt.wantCircuit {
+				t.Errorf("circuit is %s, want %s", got, tt.wantCircuit)
+			}
+			if got := rt.window == prev.window; got != tt.wantWindow {
+				t.Errorf("window kept = %t, want %t", got, tt.wantWindow)
+			}
+		})
+	}
+}
+
+func TestStartTargetsResumesAfterReload(t *testing.T) {
+	var sent atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		sent.Add(1)
+	}))
+	defer srv.Close()
+
+	clock := NewFakeClock(testEpoch)
+	cfg := testConfig(srv.URL)
+	cfg.Clock = clock
+	cfg.StartupDelay = time.Minute
+
+	ctx := context.Background()
+	reports := &inFlight{}
+	states := &targetStates{}
+	stopTargets := startTargets(ctx, ctx, cfg, reports, states)
+
+	// The startup delay, then the initial report and the period ticker
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(cfg.StartupDelay)
+	waitFor(t, func() bool { return sent.Load() == 1 && reports.Pending() == 0 && clock.Waiters() == 1 })
+
+	cfg.StatusMessage = "reloaded"
+	stopTargets()
+	stopTargets = startTargets(ctx, ctx, cfg, reports, states)
+	defer stopTargets()
+
+	// Straight to the period ticker, without a startup delay or initial report
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	if reports.Pending() != 0 || sent.Load() != 1 {
+		t.Fatal("reload sent an initial report")
+	}
+
+	clock.Advance(cfg.ReportPeriod)
+	waitFor(t, func() bool { return sent.Load() == 2 })
+}
+
+func TestRunTargetBoundsConcurrentReports(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxConcurrent int
+		wantArrived   int64
+		wantSkip      bool
+	}{
+		{name: "one at a time", maxConcurrent: 1, wantArrived: 1, wantSkip: true},
+		{name: "two at a time", maxConcurrent: 2, wantArrived: 2, wantSkip: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var arrived atomic.Int64
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				arrived.Add(1)
+				<-release
+			}))
+			defer srv.Close()
+			defer close(release)
+
+			clock := NewFakeClock(testEpoch)
+			logs := &logRecorder{}
+			cfg := testConfig(srv.URL)
+			cfg.Clock = clock
+			cfg.Logger = logs.log
+			cfg.MaxConcurrentReports = tt.maxConcurrent
+
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				runTarget(context.Background(), context.Background(), stop, cfg, &inFlight{}, &targetStates{}, "test", "test")
+			}()
+			defer func() {
+				close(stop)
+				<-done
+			}()
+
+			// The first tick always yields to the running initial report
+			waitFor(t, func() bool { return arrived.Load() == 1 && clock.Waiters() == 1 })
+			clock.Advance(cfg.ReportPeriod)
+			waitFor(t, func() bool { return logs.contains("DEBUG", "skipping first tick") })
+
+			clock.Advance(cfg.ReportPeriod)
+			if tt.wantSkip {
+				waitFor(t, func() bool { return logs.contains("WARN", "Previous report still running") })
+			}
+			waitFor(t, func() bool { return arrived.Load() == tt.wantArrived })
+		})
+	}
+}
+
+func TestDaemonRejectsInvalidConfig(t *testing.T) {
+	logs := &logRecorder{}
+	cfg := testConfig("https://kuma.example.com")
+	cfg.Logger = logs.log
+	cfg.ReportPeriod = 0
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Daemon(context.Background(), cfg)
+	}()
+	defer func() { Logger = NopLogger }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Daemon kept running with an invalid config")
+	}
+
+	if !logs.contains("FATAL", "report period must be positive") || logs.contains("FATAL", "http timeout") {
+		t.Errorf("no FATAL line for the invalid config in %v", logs.logged())
+	}
+}
+
+func TestRunTargetFirstTick(t *testing.T) {
+	tests := []struct {
+		name string
+		// initialTakes is how long the initial report runs, negative while
+		// it is still running at the first tick
+		initialTakes time.Duration
+		wantSkip     bool
+	}{
+		{name: "initial report done", initialTakes: 0, wantSkip: false},
+		{name: "initial report running", initialTakes: -1, wantSkip: true},
+		{name: "initial report just done", initialTakes: 45 * time.Second, wantSkip: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var arrived atomic.Int64
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if arrived.Add(1) == 1 {
+					<-release
+				}
+			}))
+			defer srv.Close()
+			var releaseOnce sync.Once
+			releaseInitial := func() { releaseOnce.Do(func() { close(release) }) }
+			defer releaseInitial()
+
+			clock := NewFakeClock(testEpoch)
+			logs := &logRecorder{}
+			cfg := testConfig(srv.URL)
+			cfg.Clock = clock
+			cfg.Logger = logs.log
+			cfg.MaxConcurrentReports = 2
+
+			stop := make(chan struct{})
+			reports := &inFlight{}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				runTarget(context.Background(), context.Background(), stop, cfg, reports, &targetStates{}, "test", "test")
+			}()
+			defer func() {
+				close(stop)
+				<-done
+			}()
+
+			waitFor(t, func() bool { return arrived.Load() == 1 && clock.Waiters() == 1 })
+			if tt.initialTakes >= 0 {
+				clock.Advance(tt.initialTakes)
+				releaseInitial()
+				waitFor(t, func() bool { return reports.Pending() == 0 })
+			}
+			clock.Advance(cfg.ReportPeriod - max(tt.initialTakes, 0))
+
+			if tt.wantSkip {
+				waitFor(t, func() bool { return logs.contains("DEBUG", "skipping first tick") })
+			} else {
+				waitFor(t, func() bool { return arrived.Load() == 2 })
+			}
+		})
+	}
+}
+
+func TestTargetConfigOverrides(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      model.MonitorTarget
+		wantPeriod  time.Duration
+		wantRetries int
+		wantDelay   time.Duration
+	}{
+		{
+			name:       "inherits",
+			target:     model.MonitorTarget{Host: "a.example.com", ReportURL: "kuma.example.com/api/push/a"},
+			wantPeriod: time.Minute, wantRetries: 3, wantDelay: 5 * time.Second,
+		},
+		{
+			name: "overrides",
+			target: model.MonitorTarget{Host: "b.example.com", ReportURL: "kuma.example.com/api/push/b",
+				ReportPeriod: 20 * time.Second, MaxRetries: 1, RetryDelay: time.Second},
+			wantPeriod: 20 * time.Second, wantRetries: 1, wantDelay: time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.MaxRetries = 3
+			cfg.RetryDelay = 5 * time.Second
+			cfg.ReportURLs = []string{"https://backup.example.com/api/push/test"}
+			cfg.Targets = []model.MonitorTarget{tt.target}
+
+			got := targetConfig(cfg, tt.target)
+			if got.ReportPeriod != tt.wantPeriod || got.MaxRetries != tt.wantRetries || got.RetryDelay != tt.wantDelay {
+				t.Errorf("period, retries, delay = %s, %d, %s, want %s, %d, %s",
+					got.ReportPeriod, got.MaxRetries, got.RetryDelay, tt.wantPeriod, tt.wantRetries, tt.wantDelay)
+			}
+			if got.PingHost != tt.target.Host || got.ReportURL != "https://"+tt.target.ReportURL {
+				t.Errorf("host, url = %s, %s, want the target's", got.PingHost, got.ReportURL)
+			}
+			if got.Targets != nil || got.ReportURLs != nil {
+				t.Errorf("targets and failover urls leaked into the target config")
+			}
+		})
+	}
+}
+
+func TestRunTargetStartupDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		delay  time.Duration
+		cancel bool
+	}{
+		{name: "no delay"},
+		{name: "delay", delay: 30 * time.Second},
+		{name: "cancelled while waiting", delay: 30 * time.Second, cancel: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent atomic.Int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				sent.Add(1)
+			}))
+			defer srv.Close()
+
+			clock := NewFakeClock(testEpoch)
+			cfg := testConfig(srv.URL)
+			cfg.Clock = clock
+			cfg.StartupDelay = tt.delay
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stop := make(chan struct{})
+			reports := &inFlight{}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				runTarget(ctx, context.Background(), stop, cfg, reports, &targetStates{}, "test", "test")
+			}()
+
+			if tt.delay > 0 {
+				waitFor(t, func() bool { return clock.Waiters() == 1 })
+				clock.Advance(tt.delay - time.Second)
+				// Give a report started too early the time to arrive
+				time.Sleep(10 * time.Millisecond)
+				if n := sent.Load(); n != 0 {
+					t.Fatalf("%d report(s) before the delay elapsed", n)
+				}
+			}
+			if tt.cancel {
+				cancel()
+				<-done
+				if n := sent.Load(); n != 0 {
+					t.Errorf("%d report(s) after cancelling the wait", n)
+				}
+				return
+			}
+			clock.Advance(time.Second)
+			waitFor(t, func() bool { return sent.Load() == 1 })
+
+			close(stop)
+			<-done
+		})
+	}
+}
+
+func TestStartupDelayJitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		delay  time.Duration
+		jitter time.Duration
+	}{
+		{name: "none"},
+		{name: "fixed", delay: 10 * time.Second},
+		{name: "jitter only", jitter: 5 * time.Second},
+		{name: "delay and jitter", delay: 10 * time.Second, jitter: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.StartupDelay = tt.delay
+			cfg.StartupDelayJitter = tt.jitter
+
+			for range 100 {
+				if got := startupDelay(cfg); got < tt.delay || got > tt.delay+tt.jitter {
+					t.Fatalf("startupDelay() = %s, want between %s and %s", got, tt.delay, tt.delay+tt.jitter)
+				}
+			}
+		})
+	}
+}
+
+func TestClampPingCount(t *testing.T) {
+	tests := []struct {
+		count    int
+		want     int
+		wantWarn bool
+	}{
+		{count: -3, want: 1, wantWarn: true},
+		{count: 0, want: 1, wantWarn: true},
+		{count: 1, want: 1},
+		{count: 4, want: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.count), func(t *testing.T) {
+			logs := &logRecorder{}
+			Logger = logs.log
+			defer func() { Logger = NopLogger }()
+
+			cfg := testConfig("https://kuma.example.com")
+			cfg.PingCount = tt.count
+			cfg = clampPingCount(cfg)
+			if cfg.PingCount != tt.want {
+				t.Errorf("PingCount = %d, want %d", cfg.PingCount, tt.want)
+			}
+			if warned := logs.contains("WARN", "Ping count"); warned != tt.wantWarn {
+				t.Errorf("warned %t, want %t", warned, tt.wantWarn)
+			}
+			if args := systemPingArgs("linux", cfg, "127.0.0.1"); !slices.Contains(args, fmt.Sprint(tt.want)) {
+				t.Errorf("system ping args %q, want a count of %d", args, tt.want)
+			}
+
+			// Only the deadline of the context may end a hanging ping
+			cfg.PingProvider = nil
+			cfg.PingDeadline = time.Minute
+			cfg.PingInterval = 10 * time.Millisecond
+			cfg.PrivilegedPing = true
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			result, err := pingWithGoPing(ctx, cfg, "127.0.0.1")
+			if errors.Is(err, context.DeadlineExceeded) {
+				t.Fatal("ping hung until the context deadline")
+			}
+			if err != nil {
+				t.Skipf("no ICMP socket in this environment: %v", err)
+			}
+			if result.Sent != tt.want {
+				t.Errorf("sent %d packets, want %d", result.Sent, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportDownOnShutdown(t *testing.T) {
+	tests := []struct {
+		name       string
+		reportDown bool
+		message    string
+		wantStatus string
+		wantMsg    string
+	}{
+		{name: "final up report", wantStatus: "up", wantMsg: "OK"},
+		{name: "down report", reportDown: true, wantStatus: "down", wantMsg: defaultShutdownMessage},
+		{name: "maintenance message", reportDown: true, message: "Maintenance", wantStatus: "down", wantMsg: "Maintenance"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := make(chan url.Values, 4)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				queries <- r.URL.Query()
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			cfg.Clock = NewFakeClock(testEpoch)
+			cfg.StatusMessage = "OK"
+			cfg.ReportDownOnShutdown = tt.reportDown
+			cfg.ShutdownMessage = tt.message
+			cfg.ShutdownTimeout = 10 * time.Second
+
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			reports := &inFlight{}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				runTarget(ctx, context.Background(), make(chan struct{}), cfg, reports, &targetStates{}, "test", "test")
+			}()
+			if initial := <-queries; initial.Get("status") != "up" {
+				t.Fatalf("initial report %v, want up", initial)
+			}
+
+			cancel(ErrGracefulShutdown)
+			<-done
+			waitFor(t, func() bool { return reports.Pending() == 0 })
+
+			select {
+			case final := <-queries:
+				if final.Get("status") != tt.wantStatus || final.Get("msg") != tt.wantMsg {
+					t.Errorf("final report %s %q, want %s %q", final.Get("status"), final.Get("msg"), tt.wantStatus, tt.wantMsg)
+				}
+			default:
+				t.Fatal("no report on shutdown")
+			}
+		})
+	}
+}
+
+func TestReportShutdownWaitsForRunningCycle(t *testing.T) {
+	tests := []struct {
+		name     string
+		finish   bool
+		wantWarn bool
+	}{
+		{name: "cycle finishes", finish: true},
+		{name: "cycle runs past the timeout", wantWarn: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent atomic.Int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				sent.Add(1)
+			}))
+			defer srv.Close()
+
+			clock := NewFakeClock(testEpoch)
+			logs := &logRecorder{}
+			cfg := testConfig(srv.URL)
+			cfg.Clock = clock
+			cfg.Logger = logs.log
+			sinks, err := reportSinks(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var running sync.WaitGroup
+			running.Add(1)
+			defer func() {
+				if !tt.finish {
+					running.Done()
+				}
+			}()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				reportShutdown(context.Background(), cfg, sinks, &running)
+			}()
+
+			waitFor(t, func() bool { return clock.Waiters() == 1 })
+			// Give a report sent too early the time to arrive
+			time.Sleep(10 * time.Millisecond)
+			if sent.Load() != 0 {
+				t.Fatal("shutdown report sent while the cycle was running")
+			}
+			if tt.finish {
+				running.Done()
+			} else {
+				clock.Advance(shutdownReportTimeout)
+			}
+			<-done
+
+			if sent.Load() != 1 {
+				t.Errorf("sent %d shutdown reports, want 1", sent.Load())
+			}
+			if warned := logs.contains("WARN", "sending the shutdown report anyway"); warned != tt.wantWarn {
+				t.Errorf("warned %t, want %t", warned, tt.wantWarn)
+			}
+		})
+	}
+}
+
+func TestRunTargetMinReportGap(t *testing.T) {
+	tests := []struct {
+		name string
+		// cycleTakes is how long the report of the first tick runs, the next
+		// tick comes a period after it started
+		cycleTakes time.Duration
+		wantSkip   bool
+	}{
+		{name: "gap kept", cycleTakes: 35 * time.Second, wantSkip: false},
+		{name: "cycle ends right before the tick", cycleTakes: 50 * time.Second, wantSkip: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var arrived atomic.Int64
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if arrived.Add(1) == 2 {
+					<-release
+				}
+			}))
+			defer srv.Close()
+
+			clock := NewFakeClock(testEpoch)
+			logs := &logRecorder{}
+			cfg := testConfig(srv.URL)
+			cfg.Clock = clock
+			cfg.Logger = logs.log
+			cfg.MinReportGap = 20 * time.Second
+
+			stop := make(chan struct{})
+			reports := &inFlight{}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				runTarget(context.Background(), context.Background(), stop, cfg, reports, &targetStates{}, "test", "test")
+			}()
+			defer func() {
+				close(stop)
+				<-done
+			}()
+
+			waitFor(t, func() bool { return arrived.Load() == 1 && reports.Pending() == 0 && clock.Waiters() == 1 })
+			clock.Advance(cfg.ReportPeriod)
+			waitFor(t, func() bool { return arrived.Load() == 2 })
+			clock.Advance(tt.cycleTakes)
+			close(release)
+			waitFor(t, func() bool { return reports.Pending() == 0 })
+			clock.Advance(cfg.ReportPeriod - tt.cycleTakes)
+
+			if tt.wantSkip {
+				waitFor(t, func() bool { return logs.contains("INFO", "skipping this tick") })
+				if n := arrived.Load(); n != 2 {
+					t.Errorf("sent %d reports, want the tick skipped", n)
+				}
+			} else {
+				waitFor(t, func() bool { return arrived.Load() == 3 })
+			}
+		})
+	}
+}
+
+func TestFinalReportWaitsForRunningCycle(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      bool
+		wantRequests int64
+	}{
+		{name: "cycle finishes", wantRequests: 2},
+		{name: "cycle runs past the shutdown timeout", timeout: true, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var arrived atomic.Int64
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if arrived.Add(1) == 1 {
+					<-release
+				}
+			}))
+			defer srv.Close()
+
+			clock := NewFakeClock(testEpoch)
+			logs := &logRecorder{}
+			cfg := testConfig(srv.URL)
+			cfg.Clock = clock
+			cfg.Logger = logs.log
+			cfg.ShutdownTimeout = 10 * time.Second
+
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			reports := &inFlight{}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				runTarget(ctx, context.Background(), make(chan struct{}), cfg, reports, &targetStates{}, "test", "test")
+			}()
+
+			// The initial report holds the slot while the shutdown starts
+			waitFor(t, func() bool { return arrived.Load() == 1 && clock.Waiters() == 1 })
+			cancel(ErrGracefulShutdown)
+			<-done
+			waitFor(t, func() bool { return logs.contains("DEBUG", "Waiting for the running report") })
+			if tt.timeout {
+				waitFor(t, func() bool { return clock.Waiters() == 1 })
+				clock.Advance(cfg.ShutdownTimeout)
+				waitFor(t, func() bool { return logs.contains("WARN", "skipping the final report") })
+			}
+			close(release)
+			waitFor(t, func() bool { return reports.Pending() == 0 })
+
+			if got := arrived.Load(); got != tt.wantRequests {
+				t.Errorf("sent %d reports, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package method
+
+import (
+	"context"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+type dnsCacheEntry struct {
+	ips      []net.IP
+	resolved time.Time
+}
+
+var (
+	dnsCacheMu sync.Mutex
+	dnsCache   = map[string]dnsCacheEntry{}
+)
+
+// hostOverride returns the addresses pinned for host in HostOverrides, a
+// comma separated list. Host names match case-insensitively.
+func hostOverride(cfg model.Config, host string) ([]net.IP, bool) {
+	for name, value := range cfg.HostOverrides {
+		if !strings.EqualFold(name, host) {
+			continue
+		}
+
+		var ips []net.IP
+		for _, field := range strings.Split(value, ",") {
+			if ip := net.ParseIP(strings.TrimSpace(field)); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		return ips, true
+	}
+
+	return nil, false
+}
+
+// lookupIP resolves host, reusing the cached answer while it is younger than
+// DNSCacheTTL. With UseStaleDNSOnError a failed lookup falls back to the last
+// good answer regardless of its age. HostOverrides entries skip all of it.
+func lookupIP(ctx context.Context, cfg model.Config, host string) ([]net.IP, error) {
+	if ips, ok := hostOverride(cfg, host); ok {
+		return ips, nil
+	}
+	if cfg.DNSCacheTTL <= 0 && !cfg.UseStaleDNSOnError {
+		return newResolver(cfg).LookupIP(ctx, "ip", host)
+	}
+
+	dnsCacheMu.Lock()
+	entry, cached := dnsCache[host]
+	dnsCacheMu.Unlock()
+
+	if cached && clockOf(cfg).Now().Sub(entry.resolved) < cfg.DNSCacheTTL {
+		return entry.ips, nil
+	}
+
+	ips, err := newResolver(cfg).LookupIP(ctx, "ip", host)
+	if err != nil {
+		if cached && cfg.UseStaleDNSOnError {
+			cfg.Logger("WARN", "DNS resolution failed, using stale addresses for ", host, ": ", err)
+			return entry.ips, nil
+		}
+		return nil, err
+	}
+
+	dnsCacheMu.Lock()
+	dnsCache[host] = dnsCacheEntry{ips: ips, resolved: clockOf(cfg).Now()}
+	dnsCacheMu.Unlock()
+
+	return ips, nil
+}
+
+// newResolver returns the system resolver, or one that sends every query to
+// DNSServer when set.
+func newResolver(cfg model.Config) *net.Resolver {
+	if cfg.DNSServer == "" {
+		return net.DefaultResolver
+	}
+
+	server := cfg.DNSServer
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
@@ -0,0 +1,158 @@
+package method
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+// stubDNS answers every A query on a local UDP port with ip and every other
+// query with no records, counting the questions it got.
+type stubDNS struct {
+	addr    string
+	queries atomic.Int64
+}
+
+func newStubDNS(t *testing.T, ip net.IP) *stubDNS {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	stub := &stubDNS{addr: conn.LocalAddr().String()}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			stub.queries.Add(1)
+			if reply := stubDNSReply(buf[:n], ip.To4()); reply != nil {
+				_, _ = conn.WriteTo(reply, peer)
+			}
+		}
+	}()
+
+	return stub
+}
+
+// stubDNSReply answers the single question of query, with one A record when
+// it asks for one.
+func stubDNSReply(query []byte, ip net.IP) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5 // root label, type and class
+	if end > len(query) {
+		return nil
+	}
+
+	reply := slices.Clone(query[:end])
+	binary.BigEndian.PutUint16(reply[2:], 0x8180) // response, recursion available
+	binary.BigEndian.PutUint16(reply[6:], 0)
+	binary.BigEndian.PutUint16(reply[8:], 0)
+	binary.BigEndian.PutUint16(reply[10:], 0)
+	if qtype := binary.BigEndian.Uint16(query[end-4:]); qtype != 1 {
+		return reply
+	}
+
+	binary.BigEndian.PutUint16(reply[6:], 1)
+	// Name pointer to the question, type A, class IN, TTL 60, 4 bytes of address
+	reply = append(reply, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+	return append(reply, ip...)
+}
+
+func TestNewResolver(t *testing.T) {
+	stub := newStubDNS(t, net.IPv4(10, 1, 2, 3))
+
+	tests := []struct {
+		name        string
+		server      string
+		wantDefault bool
+		wantIP      string
+	}{
+		{name: "system", wantDefault: true},
+		{name: "custom server", server: stub.addr, wantIP: "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.DNSServer = tt.server
+
+			resolver := newResolver(cfg)
+			if (resolver == net.DefaultResolver) != tt.wantDefault {
+				t.Fatalf("default resolver %t, want %t", resolver == net.DefaultResolver, tt.wantDefault)
+			}
+			if tt.wantDefault {
+				return
+			}
+
+			ips, err := resolver.LookupIP(context.Background(), "ip4", "split.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ips) != 1 || ips[0].String() != tt.wantIP {
+				t.Errorf("resolved %v, want %s", ips, tt.wantIP)
+			}
+			if stub.queries.Load() == 0 {
+				t.Error("the configured server was never asked")
+			}
+		})
+	}
+}
+
+func TestResolveIPHostOverrides(t *testing.T) {
+	tests := []struct {
+		name        string
+		host        string
+		overrides   map[string]string
+		useIPv6     bool
+		want        []string
+		wantQueries bool
+	}{
+		{name: "override", host: "canary.example.com", overrides: map[string]string{"canary.example.com": "192.0.2.7"}, want: []string{"192.0.2.7"}},
+		{name: "case insensitive", host: "Canary.Example.com", overrides: map[string]string{"canary.example.COM": "192.0.2.7"}, want: []string{"192.0.2.7"}},
+		{
+			name:      "several addresses",
+			host:      "canary.example.com",
+			overrides: map[string]string{"canary.example.com": "192.0.2.7, 2001:db8::7,192.0.2.8"},
+			useIPv6:   true,
+			want:      []string{"192.0.2.7", "2001:db8::7", "192.0.2.8"},
+		},
+		{name: "family filter", host: "canary.example.com", overrides: map[string]string{"canary.example.com": "2001:db8::7,192.0.2.8"}, want: []string{"192.0.2.8"}},
+		{name: "other host", host: "split.example.com", overrides: map[string]string{"canary.example.com": "192.0.2.7"}, want: []string{"10.1.2.3"}, wantQueries: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubDNS(t, net.IPv4(10, 1, 2, 3))
+			cfg := testConfig("https://kuma.example.com")
+			cfg.DNSServer = stub.addr
+			cfg.HostOverrides = tt.overrides
+			cfg.UseIPv6 = tt.useIPv6
+
+			ips, err := resolveIP(context.Background(), cfg, tt.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ips, tt.want) {
+				t.Errorf("resolved %v, want %v", ips, tt.want)
+			}
+			if asked := stub.queries.Load() > 0; asked != tt.wantQueries {
+				t.Errorf("DNS asked %t, want %t", asked, tt.wantQueries)
+			}
+		})
+	}
+}
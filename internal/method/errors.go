@@ -0,0 +1,72 @@
+package method
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+// retryableError tags an error with whether another attempt can help.
+// Untagged errors are treated as retryable.
+type retryableError struct {
+	err       error
+	retryable bool
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+func retryable(err error) error {
+	return &retryableError{err: err, retryable: true}
+}
+
+func terminal(err error) error {
+	return &retryableError{err: err, retryable: false}
+}
+
+func isRetryable(err error) bool {
+	var re *retryableError
+	if errors.As(err, &re) {
+		return re.retryable
+	}
+
+	return true
+}
+
+// classifyNetError marks lookups of hosts that do not exist as terminal,
+// anything else (timeouts, refused connections) may recover.
+func classifyNetError(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return terminal(err)
+	}
+
+	return retryable(err)
+}
+
+// classifyDNSError marks failed lookups as terminal unless the resolver
+// reported a timeout or temporary failure, so a name that does not resolve
+// is reported down once instead of being looked up MaxRetries times.
+func classifyDNSError(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && (dnsErr.IsTimeout || dnsErr.IsTemporary) {
+		return retryable(err)
+	}
+
+	return terminal(err)
+}
+
+// classifyStatus treats server errors, timeouts and rate limits as
+// retryable and every other unexpected status as terminal.
+func classifyStatus(code int, err error) error {
+	if code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests {
+		return retryable(err)
+	}
+
+	return terminal(err)
+}
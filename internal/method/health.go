@@ -0,0 +1,29 @@
+package method
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+var (
+	// daemonAlive is set while the Daemon loop runs
+	daemonAlive atomic.Bool
+	// reportSucceeded is set once any report went through
+	reportSucceeded atomic.Bool
+)
+
+func healthzHandler(w http.ResponseWriter, _ *http.Request) {
+	writeProbe(w, daemonAlive.Load())
+}
+
+func readyzHandler(w http.ResponseWriter, _ *http.Request) {
+	writeProbe(w, daemonAlive.Load() && reportSucceeded.Load())
+}
+
+func writeProbe(w http.ResponseWriter, ok bool) {
+	if !ok {
+		http.Error(w, "not ok", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,67 @@
+package method
+
+import (
+	"context"
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"time"
+)
+
+// pingWithHTTP issues a GET to CheckURL and reports the time to first byte.
+func pingWithHTTP(ctx context.Context, cfg model.Config) (model.PingResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.CheckURL, nil)
+	if err != nil {
+		err = terminal(fmt.Errorf("invalid check URL: %w", err))
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, err
+	}
+
+	var start, firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			firstByte = time.Now()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	client := &http.Client{
+		Timeout: pingDeadline(cfg),
+	}
+
+	start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		err = classifyNetError(fmt.Errorf("http check failed: %w", err))
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if !checkStatusAccepted(resp.StatusCode, cfg.ExpectedStatusCodes) {
+		err = fmt.Errorf("http check got unexpected status: %s", resp.Status)
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, err
+	}
+
+	if firstByte.IsZero() {
+		firstByte = time.Now()
+	}
+
+	rtt := firstByte.Sub(start).Seconds() * 1000
+	return model.PingResult{AvgRttMs: rtt, MinRttMs: rtt, MaxRttMs: rtt, Sent: 1, Recv: 1}, nil
+}
+
+// checkStatusAccepted accepts any 2xx or 3xx status when no codes are configured.
+func checkStatusAccepted(code int, expected []int) bool {
+	if len(expected) == 0 {
+		return code >= 200 && code < 400
+	}
+
+	return slices.Contains(expected, code)
+}
@@ -0,0 +1,188 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultReprobeInterval is how long the reporter sticks to a fallback URL
+// before trying the primary again when ReportURLReprobeInterval is unset.
+const defaultReprobeInterval = 5 * time.Minute
+
+// httpReporter pushes heartbeats to ReportURL, the default Reporter. With
+// ReportURLs it fails over to the next URL and sticks to the one that
+// answered, probing the primary again every ReportURLReprobeInterval.
+type httpReporter struct {
+	cfg       model.Config
+	endpoints []httpEndpoint
+	breaker   *circuitBreaker
+
+	mu        sync.Mutex
+	active    int
+	lastProbe time.Time
+}
+
+type httpEndpoint struct {
+	cfg    model.Config
+	client *http.Client
+}
+
+// NewHTTPReporter returns the Uptime Kuma push Reporter for cfg.ReportURL,
+// failing over to cfg.ReportURLs.
+func NewHTTPReporter(cfg model.Config) (model.Reporter, error) {
+	var urls []string
+	if cfg.ReportURL != "" {
+		urls = append(urls, cfg.ReportURL)
+	}
+	urls = append(urls, cfg.ReportURLs...)
+
+	reporter := &httpReporter{cfg: cfg}
+	for _, reportURL := range urls {
+		endpointCfg := cfg
+		endpointCfg.ReportURL = model.NormalizeReportURL(reportURL)
+		client, err := newReportClient(endpointCfg)
+		if err != nil {
+			return nil, err
+		}
+		if _, httpURL, ok := splitUnixSocketURL(endpointCfg.ReportURL); ok {
+			endpointCfg.ReportURL = httpURL
+		}
+		reporter.endpoints = append(reporter.endpoints, httpEndpoint{cfg: endpointCfg, client: client})
+	}
+	if len(reporter.endpoints) == 0 {
+		return nil, errors.New("no report URL configured")
+	}
+
+	if cfg.CircuitBreakerThreshold > 0 {
+		reporter.breaker = &circuitBreaker{cfg: cfg}
+	}
+	return reporter, nil
+}
+
+func (r *httpReporter) Report(ctx context.Context, hb model.Heartbeat) error {
+	if r.breaker == nil {
+		return r.send(ctx, hb)
+	}
+
+	if err := r.breaker.allow(); err != nil {
+		r.cfg.Logger("WARN", "Report skipped: ", err)
+		return err
+	}
+	err := r.send(ctx, hb)
+	r.breaker.record(err)
+	return err
+}
+
+// send tries the endpoints starting with the active one until one accepts
+// the heartbeat.
+func (r *httpReporter) send(ctx context.Context, hb model.Heartbeat) error {
+	var errs []error
+	for _, i := range r.order() {
+		endpoint := r.endpoints[i]
+		err := sendReport(ctx, endpoint.cfg, endpoint.client, hb)
+		if err == nil {
+			r.setActive(i)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// order lists the endpoint indexes to try, the active one first unless the
+// primary is due for a probe.
+func (r *httpReporter) order() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	start := r.active
+	interval := r.cfg.ReportURLReprobeInterval
+	if interval <= 0 {
+		interval = defaultReprobeInterval
+	}
+	now := clockOf(r.cfg).Now()
+	if start != 0 && now.Sub(r.lastProbe) >= interval {
+		r.cfg.Logger("DEBUG", "Probing the primary report URL again")
+		r.lastProbe = now
+		start = 0
+	}
+
+	order := []int{start}
+	for i := range r.endpoints {
+		if i != start {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+func (r *httpReporter) setActive(i int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i == r.active {
+		return
+	}
+	if i == 0 {
+		r.cfg.Logger("INFO", "Primary report URL is back, switching to it")
+	} else {
+		r.cfg.Logger("WARN", "Failing over to report URL ", RedactURLString(r.endpoints[i].cfg.ReportURL))
+	}
+	r.active = i
+	r.lastProbe = clockOf(r.cfg).Now()
+}
+
+// inherit takes over the failover and circuit state of prev, the reporter
+// it replaces on a reload, as long as both push to the same URLs.
+func (r *httpReporter) inherit(prev *httpReporter) {
+	sameURLs := slices.EqualFunc(r.endpoints, prev.endpoints, func(a, b httpEndpoint) bool {
+		return a.cfg.ReportURL == b.cfg.ReportURL
+	})
+	if !sameURLs {
+		return
+	}
+
+	prev.mu.Lock()
+	r.active, r.lastProbe = prev.active, prev.lastProbe
+	prev.mu.Unlock()
+
+	if r.breaker != nil && prev.breaker != nil {
+		prev.breaker.mu.Lock()
+		r.breaker.state, r.breaker.failures, r.breaker.openedAt = prev.breaker.state, prev.breaker.failures, prev.breaker.openedAt
+		prev.breaker.mu.Unlock()
+	}
+}
+
+// reportSinks returns the reporters of a target, the ReportURL push first
+// when set, over the ReportTransport. The first one is the primary.
+func reportSinks(cfg model.Config) ([]model.Reporter, error) {
+	var sinks []model.Reporter
+	if cfg.ReportURL != "" || len(cfg.ReportURLs) > 0 {
+		newReporter := NewHTTPReporter
+		switch cfg.ReportTransport {
+		case "", transportHTTP:
+		case transportWebSocket:
+			newReporter = NewWebSocketReporter
+		default:
+			return nil, fmt.Errorf("unsupported report transport %q", cfg.ReportTransport)
+		}
+
+		push, err := newReporter(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, push)
+	}
+
+	return append(sinks, cfg.Reporters...), nil
+}
@@ -0,0 +1,120 @@
+package method
+
+import (
+	"context"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPReporterFailover(t *testing.T) {
+	var mu sync.Mutex
+	var hits []string
+	var primaryDown, secondaryDown atomic.Bool
+	endpoint := func(name string, down *atomic.Bool) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			mu.Lock()
+			hits = append(hits, name)
+			mu.Unlock()
+			if down != nil && down.Load() {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	primary := endpoint("primary", &primaryDown)
+	secondary := endpoint("secondary", &secondaryDown)
+	tertiary := endpoint("tertiary", nil)
+
+	clock := NewFakeClock(testEpoch)
+	cfg := testConfig(primary.URL)
+	cfg.ReportURLs = []string{secondary.URL + "/api/push/test", tertiary.URL + "/api/push/test"}
+	cfg.ReportURLReprobeInterval = 5 * time.Minute
+	cfg.Clock = clock
+	reporter, err := NewHTTPReporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One report per step, in order
+	steps := []struct {
+		name          string
+		advance       time.Duration
+		primaryDown   bool
+		secondaryDown bool
+		want          []string
+	}{
+		{name: "primary", want: []string{"primary"}},
+		{name: "failover", primaryDown: true, want: []string{"primary", "secondary"}},
+		{name: "sticky", primaryDown: true, want: []string{"secondary"}},
+		{name: "before the reprobe", advance: 4 * time.Minute, primaryDown: true, want: []string{"secondary"}},
+		{name: "reprobe still down", advance: time.Minute, primaryDown: true, want: []string{"primary", "secondary"}},
+		{name: "reprobe interval restarts", advance: time.Minute, primaryDown: true, want: []string{"secondary"}},
+		{name: "next in order", primaryDown: true, secondaryDown: true, want: []string{"secondary", "primary", "tertiary"}},
+		{name: "primary back", advance: 5 * time.Minute, want: []string{"primary"}},
+		{name: "sticky primary", want: []string{"primary"}},
+	}
+
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		primaryDown.Store(step.primaryDown)
+		secondaryDown.Store(step.secondaryDown)
+		mu.Lock()
+		hits = nil
+		mu.Unlock()
+
+		if err := reporter.Report(context.Background(), model.Heartbeat{Status: statusUp, Msg: "OK"}); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		mu.Lock()
+		got := slices.Clone(hits)
+		mu.Unlock()
+		if !slices.Equal(got, step.want) {
+			t.Errorf("%s: tried %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestHTTPReporterAllDown(t *testing.T) {
+	tests := []struct {
+		name      string
+		fallbacks int
+	}{
+		{name: "single URL"},
+		{name: "with fallbacks", fallbacks: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			for range tt.fallbacks {
+				cfg.ReportURLs = append(cfg.ReportURLs, srv.URL+"/api/push/test")
+			}
+			reporter, err := NewHTTPReporter(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = reporter.Report(context.Background(), model.Heartbeat{Status: statusUp, Msg: "OK"})
+			if err == nil || !isRetryable(err) {
+				t.Errorf("Report() = %v, want a retryable error", err)
+			}
+			if got, want := requests.Load(), int64(tt.fallbacks+1); got != want {
+				t.Errorf("sent %d requests, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package method
+
+import (
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inFlight tracks report goroutines so shutdown can wait for them.
+type inFlight struct {
+	wg      sync.WaitGroup
+	pending atomic.Int64
+}
+
+func (f *inFlight) Go(fn func()) {
+	f.wg.Add(1)
+	f.pending.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer f.pending.Add(-1)
+		fn()
+	}()
+}
+
+func (f *inFlight) Pending() int64 {
+	return f.pending.Load()
+}
+
+// Wait blocks until every tracked goroutine returned or timeout elapsed,
+// reporting whether they all finished.
+func (f *inFlight) Wait(clock model.Clock, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-clock.After(timeout):
+		return false
+	}
+}
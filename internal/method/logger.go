@@ -1,7 +1,258 @@
 package method
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
+var logLevels = map[string]int{
+	"DEBUG": 0,
+	"INFO":  1,
+	"WARN":  2,
+	"ERROR": 3,
+	"FATAL": 4,
+}
+
+// levelFilter drops messages below minLevel before they reach logger.
+// An empty or unknown minLevel keeps everything.
+func levelFilter(minLevel string, logger func(string, ...any)) func(string, ...any) {
+	threshold, ok := logLevels[strings.ToUpper(minLevel)]
+	if !ok {
+		return logger
+	}
+
+	return func(Type string, log ...any) {
+		if level, ok := logLevels[Type]; ok && level < threshold {
+			return
+		}
+		logger(Type, log...)
+	}
+}
+
+// NopLogger discards everything, for embedders that handle events themselves.
+func NopLogger(string, ...any) {}
+
+// stdoutMu serializes DefaultLogger, which is called from every report
+// goroutine at once.
+var stdoutMu sync.Mutex
+
+// DefaultLogger prints "<RFC3339 time> [LEVEL] message" lines to stdout.
 func DefaultLogger(Type string, log ...any) {
-	fmt.Printf("[%s] %s\n", Type, fmt.Sprint(log...))
+	defaultLogFormat.stdout(Type, log...)
+}
+
+const defaultLogLayout = "{time} [{level}] {msg}"
+
+var defaultLogFormat, _ = parseLogFormat(defaultLogLayout)
+
+// logFormat is a parsed LogFormat layout, alternating literal text and
+// placeholders.
+type logFormat []logSegment
+
+type logSegment struct {
+	text        string
+	placeholder bool
+}
+
+// parseLogFormat splits a layout like "{time} [{level}] {msg}" once, so
+// logging only fills in the placeholders. Known ones are {time}, {level},
+// {target} and {msg}; without {target} the target tag stays in {msg}.
+func parseLogFormat(layout string) (logFormat, error) {
+	var format logFormat
+	for layout != "" {
+		start := strings.IndexByte(layout, '{')
+		if start < 0 {
+			format = append(format, logSegment{text: layout})
+			break
+		}
+		if start > 0 {
+			format = append(format, logSegment{text: layout[:start]})
+		}
+
+		end := strings.IndexByte(layout[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in %q", layout)
+		}
+		name := layout[start+1 : start+end]
+		switch name {
+		case "time", "level", "target", "msg":
+		default:
+			return nil, fmt.Errorf("unknown placeholder {%s}", name)
+		}
+		format = append(format, logSegment{text: name, placeholder: true})
+		layout = layout[start+end+1:]
+	}
+
+	return format, nil
+}
+
+func (f logFormat) hasTarget() bool {
+	for _, segment := range f {
+		if segment.placeholder && segment.text == "target" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// line renders one log call as a newline terminated line.
+func (f logFormat) line(Type string, log []any) string {
+	var target string
+	if len(log) > 0 && f.hasTarget() {
+		if tag, ok := log[0].(targetTag); ok {
+			target = string(tag)
+			log = log[1:]
+		}
+	}
+
+	var b strings.Builder
+	for _, segment := range f {
+		if !segment.placeholder {
+			b.WriteString(segment.text)
+			continue
+		}
+		switch segment.text {
+		case "time":
+			b.WriteString(time.Now().Format(time.RFC3339))
+		case "level":
+			b.WriteString(Type)
+		case "target":
+			b.WriteString(target)
+		case "msg":
+			b.WriteString(fmt.Sprint(log...))
+		}
+	}
+	b.WriteByte('\n')
+
+	return b.String()
+}
+
+// stdout is DefaultLogger with this format.
+func (f logFormat) stdout(Type string, log ...any) {
+	line := f.line(Type, log)
+
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	_, _ = os.Stdout.WriteString(line)
+}
+
+// withCaller adds the file:line of the code that logged, after the target
+// tag when there is one.
+func withCaller(logger func(string, ...any)) func(string, ...any) {
+	return func(Type string, log ...any) {
+		location := callerLocation()
+		if location == "" {
+			logger(Type, log...)
+			return
+		}
+
+		if len(log) > 0 {
+			if tag, ok := log[0].(targetTag); ok {
+				logger(Type, append([]any{tag, location, ": "}, log[1:]...)...)
+				return
+			}
+		}
+		logger(Type, append([]any{location, ": "}, log...)...)
+	}
+}
+
+// callerLocation returns the first frame outside the logger wrappers.
+func callerLocation() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasSuffix(frame.File, "/internal/method/logger.go") {
+			return fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// NewFileLogger returns a logger that appends plain lines to path, rotating
+// the file once it exceeds maxSizeMB and keeping maxBackups old files. A
+// maxSizeMB of zero disables rotation.
+func NewFileLogger(path string, maxSizeMB, maxBackups int) (func(string, ...any), error) {
+	return newFileLogger(path, maxSizeMB, maxBackups, defaultLogFormat)
+}
+
+func newFileLogger(path string, maxSizeMB, maxBackups int, format logFormat) (func(string, ...any), error) {
+	file, err := openRotatingFile(path, maxSizeMB, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(Type string, log ...any) {
+		_, _ = file.Write([]byte(format.line(Type, log)))
+	}, nil
+}
+
+// NewJSONLogger returns a logger that writes one JSON object per line to w,
+// suitable for Config.Logger.
+func NewJSONLogger(w io.Writer) func(string, ...any) {
+	var mu sync.Mutex
+
+	return func(Type string, log ...any) {
+		entry := struct {
+			Level  string `json:"level"`
+			Time   string `json:"time"`
+			Msg    string `json:"msg"`
+			Target string `json:"target,omitempty"`
+		}{
+			Level: Type,
+			Time:  time.Now().Format("2006-01-02T15:04:05.000Z07:00"),
+		}
+
+		if len(log) > 0 {
+			if tag, ok := log[0].(targetTag); ok {
+				entry.Target = string(tag)
+				log = log[1:]
+			}
+		}
+		entry.Msg = fmt.Sprint(log...)
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(append(line, '\n'))
+	}
+}
+
+// targetTag carries the target name as the first log argument so structured
+// loggers can pull it out, while plain loggers print it as a prefix.
+type targetTag string
+
+func (t targetTag) String() string {
+	return "[" + string(t) + "] "
+}
+
+// millis is a duration in milliseconds that only formats itself, with two
+// decimals, when the line is actually logged.
+type millis float64
+
+func (m millis) String() string {
+	return strconv.FormatFloat(float64(m), 'f', 2, 64) + " ms"
+}
+
+// prefixLogger tags every line with the given target name.
+func prefixLogger(name string, logger func(string, ...any)) func(string, ...any) {
+	return func(Type string, log ...any) {
+		logger(Type, append([]any{targetTag(name)}, log...)...)
+	}
 }
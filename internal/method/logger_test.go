@@ -0,0 +1,356 @@
+package method
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type logEntry struct {
+	level string
+	msg   string
+}
+
+// logRecorder is a Config.Logger keeping every call.
+type logRecorder struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (r *logRecorder) log(Type string, log ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, logEntry{level: Type, msg: fmt.Sprint(log...)})
+}
+
+func (r *logRecorder) logged() []logEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]logEntry(nil), r.entries...)
+}
+
+// contains reports whether a line of level has msg in it.
+func (r *logRecorder) contains(level, msg string) bool {
+	for _, entry := range r.logged() {
+		if entry.level == level && strings.Contains(entry.msg, msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestReportCycleLogLevels(t *testing.T) {
+	tests := []struct {
+		name   string
+		rtts   []float64
+		status int
+		want   logEntry
+	}{
+		{name: "up", rtts: []float64{10}, status: http.StatusOK, want: logEntry{"INFO", "Report successful!"}},
+		{name: "down", rtts: []float64{-1}, status: http.StatusOK, want: logEntry{"WARN", "Reported down"}},
+		{name: "rejected", rtts: []float64{10}, status: http.StatusBadRequest, want: logEntry{"ERROR", "400"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			logs := &logRecorder{}
+			cfg := testConfig(srv.URL)
+			cfg.Logger = logs.log
+			cfg.PingProvider = NewSimulatedPingProvider(tt.rtts)
+			sinks, err := reportSinks(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = reportWithRetry(context.Background(), cfg, &targetRuntime{sinks: sinks})
+
+			// The level comes first, never part of the message
+			for _, entry := range logs.logged() {
+				if _, ok := logLevels[entry.level]; !ok {
+					t.Errorf("logged %q with level %q", entry.msg, entry.level)
+				}
+			}
+			if !logs.contains(tt.want.level, tt.want.msg) {
+				t.Errorf("no %s line containing %q in %v", tt.want.level, tt.want.msg, logs.logged())
+			}
+		})
+	}
+}
+
+func TestLoggersConcurrentLines(t *testing.T) {
+	const goroutines, perGoroutine = 20, 10
+	msg := strings.Repeat("x", 200)
+
+	tests := []struct {
+		name  string
+		run   func() string
+		valid func(line string) bool
+	}{
+		{
+			name: "default",
+			run: func() string {
+				return captureStdout(t, func() { logConcurrently(DefaultLogger, msg, goroutines, perGoroutine) })
+			},
+			valid: func(line string) bool { return strings.HasSuffix(line, " [INFO] "+msg) },
+		},
+		{
+			name: "json",
+			run: func() string {
+				var buf strings.Builder
+				logConcurrently(NewJSONLogger(&syncWriter{w: &buf}), msg, goroutines, perGoroutine)
+				return buf.String()
+			},
+			valid: func(line string) bool {
+				var entry map[string]string
+				return json.Unmarshal([]byte(line), &entry) == nil && entry["msg"] == msg
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := strings.Split(strings.TrimSuffix(tt.run(), "\n"), "\n")
+			if len(lines) != goroutines*perGoroutine {
+				t.Fatalf("got %d lines, want %d", len(lines), goroutines*perGoroutine)
+			}
+			for _, line := range lines {
+				if !tt.valid(line) {
+					t.Fatalf("interleaved line %q", line)
+				}
+			}
+		})
+	}
+}
+
+func logConcurrently(logger func(string, ...any), msg string, goroutines, perGoroutine int) {
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perGoroutine {
+				logger("INFO", msg)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// captureStdout returns what fn wrote to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	fn()
+	_ = w.Close()
+
+	return <-out
+}
+
+// syncWriter only guards the builder against the race detector, NewJSONLogger
+// must keep each line in one Write.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.w.Write(p)
+}
+
+func TestLogTimestampAndCaller(t *testing.T) {
+	tests := []struct {
+		name   string
+		logger func(logs *logRecorder) func(string, ...any)
+		want   string
+	}{
+		{name: "plain", logger: func(logs *logRecorder) func(string, ...any) { return logs.log }, want: "Started"},
+		{name: "caller", logger: func(logs *logRecorder) func(string, ...any) { return withCaller(logs.log) }, want: "logger_test.go:"},
+		{
+			name:   "caller after the target",
+			logger: func(logs *logRecorder) func(string, ...any) { return prefixLogger("db", withCaller(logs.log)) },
+			want:   "[db] logger_test.go:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := &logRecorder{}
+			tt.logger(logs)("INFO", "Started")
+			if !logs.contains("INFO", tt.want) {
+				t.Errorf("got %v, want a line containing %q", logs.logged(), tt.want)
+			}
+		})
+	}
+
+	line := defaultLogFormat.line("INFO", []any{"Started"})
+	stamp, rest, _ := strings.Cut(line, " ")
+	if _, err := time.Parse(time.RFC3339, stamp); err != nil {
+		t.Errorf("line %q does not start with an RFC 3339 time: %v", line, err)
+	}
+	if rest != "[INFO] Started\n" {
+		t.Errorf("line %q, want the level and message after the time", line)
+	}
+}
+
+func TestQuietDaemonPrintsNothing(t *testing.T) {
+	tests := []struct {
+		name  string
+		quiet bool
+		want  bool
+	}{
+		{name: "default", quiet: false, want: true},
+		{name: "quiet", quiet: true, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.Logger = nil
+			cfg.Quiet = tt.quiet
+			// Invalid, so the daemon logs and returns right away
+			cfg.ReportPeriod = 0
+
+			out := captureStdout(t, func() { Daemon(context.Background(), cfg) })
+			Logger = NopLogger
+			if got := strings.Contains(out, "Invalid configuration"); got != tt.want {
+				t.Errorf("printed %q, want output %t", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	tests := []struct {
+		minLevel string
+		want     []string
+	}{
+		{minLevel: "", want: []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{minLevel: "info", want: []string{"INFO", "WARN", "ERROR"}},
+		{minLevel: "ERROR", want: []string{"ERROR"}},
+		{minLevel: "verbose", want: []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.minLevel, func(t *testing.T) {
+			logs := &logRecorder{}
+			logger := levelFilter(tt.minLevel, logs.log)
+			for _, level := range []string{"DEBUG", "INFO", "WARN", "ERROR"} {
+				logger(level, "message")
+			}
+
+			var got []string
+			for _, entry := range logs.logged() {
+				got = append(got, entry.level)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("passed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// formatCounter counts how often a log argument got formatted.
+type formatCounter struct{ calls *int }
+
+func (f formatCounter) String() string {
+	*f.calls++
+	return "value"
+}
+
+func TestTargetLoggerFiltersBeforePrefix(t *testing.T) {
+	defer func(logger func(string, ...any)) { Logger = logger }(Logger)
+	var reached []string
+	Logger = func(Type string, log ...any) {
+		reached = append(reached, Type+" "+fmt.Sprint(log...))
+	}
+
+	cfg := testConfig("https://kuma.example.com")
+	cfg.LogLevel = "INFO"
+	logger := targetConfig(cfg, model.MonitorTarget{Name: "db", Host: "db.example.com"}).Logger
+
+	var formatted int
+	logger("DEBUG", "Cycle done: ", formatCounter{&formatted})
+	logger("INFO", "Ping ", millis(12.5))
+
+	if want := []string{"INFO [db] Ping 12.50 ms"}; !slices.Equal(reached, want) {
+		t.Errorf("logged %q, want %q", reached, want)
+	}
+	if formatted != 0 {
+		t.Errorf("dropped DEBUG line formatted its arguments %d times", formatted)
+	}
+}
+
+func TestParseLogFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  string
+		target  bool
+		want    string
+		wantErr string
+	}{
+		{name: "level and message", layout: "{level}: {msg}", want: "INFO: Started\n"},
+		{name: "literal only", layout: "tick", want: "tick\n"},
+		{name: "tag kept in the message", layout: "{level} {msg}", target: true, want: "INFO [db] Started\n"},
+		{name: "target placeholder", layout: "{target}|{level}|{msg}", target: true, want: "db|INFO|Started\n"},
+		{name: "target placeholder without tag", layout: "{target}|{msg}", want: "|Started\n"},
+		{name: "unknown placeholder", layout: "{host} {msg}", wantErr: "unknown placeholder {host}"},
+		{name: "brace inside a placeholder", layout: "{level {msg}", wantErr: "unknown placeholder {level {msg}"},
+		{name: "missing brace", layout: "{level} {msg", wantErr: "unclosed placeholder"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := parseLogFormat(tt.layout)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseLogFormat(%q) = %v, want an error containing %q", tt.layout, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			log := []any{"Started"}
+			if tt.target {
+				log = []any{targetTag("db"), "Started"}
+			}
+			if got := format.line("INFO", log); got != tt.want {
+				t.Errorf("line = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package method
+
+import (
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// defaultHealthchecksBody is the POST body sent to Healthchecks.io, which
+// shows it as the ping's log
+const defaultHealthchecksBody = "{{.Status}}: {{.Msg}} (rtt={{.RTT}}ms loss={{.Loss}}%)"
+
+// messageData is available to StatusMessage and ReportBodyTemplate, e.g.
+// "{{.Host}} {{.RTT}}ms loss={{.Loss}}%". Status and Msg are only set for
+// report bodies.
+type messageData struct {
+	Host     string
+	Hostname string
+	IP       string
+	RTT      string
+	Loss     string
+	Jitter   string
+	Status   string
+	Msg      string
+}
+
+func newMessageData(cfg model.Config, result model.PingResult) messageData {
+	hostname, _ := os.Hostname()
+
+	return messageData{
+		Host:     cfg.PingHost,
+		Hostname: hostname,
+		IP:       result.IP,
+		RTT:      fmt.Sprintf("%.2f", result.AvgRttMs),
+		Loss:     fmt.Sprintf("%.0f", result.PacketLoss),
+		Jitter:   fmt.Sprintf("%.2f", result.Jitter),
+	}
+}
+
+func renderTemplate(text string, data messageData) (string, error) {
+	tmpl, err := template.New("message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+// renderStatusMessage expands StatusMessage as a text/template. Messages
+// without template actions, or that fail to render, are used literally.
+func renderStatusMessage(cfg model.Config, result model.PingResult) string {
+	if !strings.Contains(cfg.StatusMessage, "{{") {
+		return cfg.StatusMessage
+	}
+
+	msg, err := renderTemplate(cfg.StatusMessage, newMessageData(cfg, result))
+	if err != nil {
+		cfg.Logger("WARN", "Cannot render status message template, sending it literally: ", err)
+		return cfg.StatusMessage
+	}
+
+	return msg
+}
@@ -0,0 +1,39 @@
+package method
+
+import (
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"testing"
+)
+
+func TestRenderStatusMessage(t *testing.T) {
+	result := model.PingResult{AvgRttMs: 12.345, PacketLoss: 25, Jitter: 1.5, IP: "192.0.2.1"}
+
+	tests := []struct {
+		name     string
+		message  string
+		wantWarn bool
+		want     string
+	}{
+		{name: "literal", message: "OK", want: "OK"},
+		{name: "values", message: "{{.Host}} via {{.IP}}: {{.RTT}}ms loss={{.Loss}}% jitter={{.Jitter}}ms",
+			want: "example.com via 192.0.2.1: 12.35ms loss=25% jitter=1.50ms"},
+		{name: "unknown field", message: "{{.Nope}}", wantWarn: true, want: "{{.Nope}}"},
+		{name: "broken template", message: "{{.RTT", wantWarn: true, want: "{{.RTT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := &logRecorder{}
+			cfg := testConfig("https://kuma.example.com")
+			cfg.Logger = logs.log
+			cfg.StatusMessage = tt.message
+
+			if got := renderStatusMessage(cfg, result); got != tt.want {
+				t.Errorf("renderStatusMessage() = %q, want %q", got, tt.want)
+			}
+			if got := logs.contains("WARN", "status message template"); got != tt.wantWarn {
+				t.Errorf("warned = %t, want %t", got, tt.wantWarn)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package method
+
+import (
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	pingRttGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kuma_reporter_ping_rtt_milliseconds",
+		Help: "Average round trip time of the last successful check.",
+	}, []string{"host"})
+
+	packetLossGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kuma_reporter_packet_loss_percent",
+		Help: "Packet loss of the last successful check.",
+	}, []string{"host"})
+
+	reportCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kuma_reporter_reports_total",
+		Help: "Report cycles by outcome.",
+	}, []string{"host", "result"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(pingRttGauge, packetLossGauge, reportCounter)
+}
+
+func recordPing(cfg model.Config, result model.PingResult) {
+	pingRttGauge.WithLabelValues(cfg.PingHost).Set(result.AvgRttMs)
+	packetLossGauge.WithLabelValues(cfg.PingHost).Set(result.PacketLoss)
+}
+
+func recordReport(cfg model.Config, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	reportCounter.WithLabelValues(cfg.PingHost, result).Inc()
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,144 @@
+//go:build otel
+
+package method
+
+import (
+	"context"
+	"errors"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"git.ghink.net/ghink/kuma-repoter/internal/version"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const otelScope = "git.ghink.net/ghink/kuma-repoter"
+
+// The instruments stay no-ops until startTelemetry installs a provider.
+var (
+	tracer trace.Tracer = noop.NewTracerProvider().Tracer(otelScope)
+
+	otelRtt     metric.Float64Histogram
+	otelLoss    metric.Float64Gauge
+	otelReports metric.Int64Counter
+)
+
+// otlpSignalURL appends the path of an OTLP signal to endpoint, with or
+// without a trailing slash. Validate already rejected unparsable endpoints.
+func otlpSignalURL(endpoint, signal string) string {
+	joined, err := url.JoinPath(endpoint, signal)
+	if err != nil {
+		return endpoint + "/" + signal
+	}
+
+	return joined
+}
+
+// startTelemetry exports traces and metrics to OTLPEndpoint over OTLP/HTTP.
+// The returned function flushes and stops the exporters.
+func startTelemetry(ctx context.Context, cfg model.Config) func() {
+	if cfg.OTLPEndpoint == "" {
+		return func() {}
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("kuma-reporter"),
+		semconv.ServiceVersion(version.Version),
+	)
+
+	traceExporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(otlpSignalURL(cfg.OTLPEndpoint, "v1/traces")))
+	if err != nil {
+		Logger("ERROR", "Cannot create OTLP trace exporter: ", err)
+		return func() {}
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(otlpSignalURL(cfg.OTLPEndpoint, "v1/metrics")))
+	if err != nil {
+		Logger("ERROR", "Cannot create OTLP metric exporter: ", err)
+		_ = traceExporter.Shutdown(ctx)
+		return func() {}
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+
+	tracer = tracerProvider.Tracer(otelScope)
+	meter := meterProvider.Meter(otelScope)
+	otelRtt, _ = meter.Float64Histogram("kuma_reporter.ping.rtt",
+		metric.WithUnit("ms"), metric.WithDescription("Average round trip time of successful checks."))
+	otelLoss, _ = meter.Float64Gauge("kuma_reporter.packet_loss",
+		metric.WithUnit("%"), metric.WithDescription("Packet loss of the last successful check."))
+	otelReports, _ = meter.Int64Counter("kuma_reporter.reports",
+		metric.WithDescription("Report cycles by outcome."))
+
+	Logger("INFO", "Exporting OpenTelemetry data to ", RedactURLString(cfg.OTLPEndpoint))
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := errors.Join(tracerProvider.Shutdown(shutdownCtx), meterProvider.Shutdown(shutdownCtx)); err != nil {
+			Logger("WARN", "OpenTelemetry shutdown: ", err)
+		}
+	}
+}
+
+// startCycleSpan opens the span of one report cycle, the returned function
+// closes it with the outcome and records the cycle metrics.
+func startCycleSpan(ctx context.Context, cfg model.Config) (context.Context, func(model.ReportResult)) {
+	ctx, span := tracer.Start(ctx, "report_cycle", trace.WithAttributes(attribute.String("host", cfg.PingHost)))
+
+	return ctx, func(res model.ReportResult) {
+		outcome := "success"
+		if !res.Success {
+			outcome = "failure"
+		}
+		attrs := []attribute.KeyValue{attribute.String("host", res.Host)}
+
+		span.SetAttributes(
+			attribute.String("status", res.Status),
+			attribute.String("outcome", outcome),
+			attribute.Int("attempts", res.Attempts),
+			attribute.Float64("rtt_ms", res.Result.AvgRttMs),
+			attribute.Float64("packet_loss", res.Result.PacketLoss),
+		)
+		endSpan(span, res.Err)
+
+		if otelReports == nil {
+			return
+		}
+		otelReports.Add(ctx, 1, metric.WithAttributes(append(attrs, attribute.String("result", outcome))...))
+		if res.Status == statusUp {
+			otelRtt.Record(ctx, res.Result.AvgRttMs, metric.WithAttributes(attrs...))
+			otelLoss.Record(ctx, res.Result.PacketLoss, metric.WithAttributes(attrs...))
+		}
+	}
+}
+
+// startSpan opens a child span for one step of the cycle, e.g. the ping.
+func startSpan(ctx context.Context, name string) (context.Context, func(error)) {
+	ctx, span := tracer.Start(ctx, name)
+
+	return ctx, func(err error) {
+		endSpan(span, err)
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
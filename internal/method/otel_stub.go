@@ -0,0 +1,26 @@
+//go:build !otel
+
+package method
+
+import (
+	"context"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+)
+
+// startTelemetry is a no-op without the otel build tag, which keeps the
+// OpenTelemetry SDK out of the default binary.
+func startTelemetry(_ context.Context, cfg model.Config) func() {
+	if cfg.OTLPEndpoint != "" {
+		Logger("WARN", "otlp_endpoint is set but this binary was built without the otel tag, not exporting")
+	}
+
+	return func() {}
+}
+
+func startCycleSpan(ctx context.Context, _ model.Config) (context.Context, func(model.ReportResult)) {
+	return ctx, func(model.ReportResult) {}
+}
+
+func startSpan(ctx context.Context, _ string) (context.Context, func(error)) {
+	return ctx, func(error) {}
+}
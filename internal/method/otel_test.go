@@ -0,0 +1,25 @@
+//go:build otel
+
+package method
+
+import "testing"
+
+func TestOTLPSignalURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+	}{
+		{name: "bare", endpoint: "http://localhost:4318", want: "http://localhost:4318/v1/traces"},
+		{name: "trailing slash", endpoint: "http://localhost:4318/", want: "http://localhost:4318/v1/traces"},
+		{name: "path prefix", endpoint: "https://otel.example.com/collector/", want: "https://otel.example.com/collector/v1/traces"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := otlpSignalURL(tt.endpoint, "v1/traces"); got != tt.want {
+				t.Errorf("otlpSignalURL(%q) = %q, want %q", tt.endpoint, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package method
+
+import (
+	"context"
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"sync/atomic"
+)
+
+// NewPingProvider returns the built-in checks configured by cfg as a
+// PingProvider, for wrapping them in a custom one.
+func NewPingProvider(cfg model.Config) model.PingProvider {
+	cfg.PingProvider = nil
+	if cfg.Logger == nil {
+		cfg.Logger = NopLogger
+	}
+
+	return &checkProvider{cfg: cfg}
+}
+
+type checkProvider struct {
+	cfg model.Config
+}
+
+func (p *checkProvider) Ping(ctx context.Context, host string) (model.PingResult, error) {
+	cfg := p.cfg
+	cfg.PingHost = host
+	result, _, err := measure(ctx, cfg, nil)
+
+	return result, err
+}
+
+// NewSimulatedPingProvider returns a PingProvider that cycles through rtts
+// (in ms) without touching the network. A negative value simulates a host
+// losing every packet.
+func NewSimulatedPingProvider(rtts []float64) model.PingProvider {
+	return &simulatedProvider{rtts: rtts}
+}
+
+type simulatedProvider struct {
+	rtts []float64
+	next atomic.Uint64
+}
+
+func (p *simulatedProvider) Ping(ctx context.Context, host string) (model.PingResult, error) {
+	if err := ctx.Err(); err != nil {
+		return model.PingResult{}, err
+	}
+	if len(p.rtts) == 0 {
+		return model.PingResult{}, terminal(fmt.Errorf("no simulated RTTs for %s", host))
+	}
+
+	rtt := p.rtts[(p.next.Add(1)-1)%uint64(len(p.rtts))]
+	if rtt < 0 {
+		return model.PingResult{Sent: 1}, fmt.Errorf("%w from %s (simulated)", errNoResponse, host)
+	}
+
+	return model.PingResult{AvgRttMs: rtt, MinRttMs: rtt, MaxRttMs: rtt, Sent: 1, Recv: 1}, nil
+}
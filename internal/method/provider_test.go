@@ -0,0 +1,186 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSimulatedPingProvider(t *testing.T) {
+	tests := []struct {
+		name string
+		rtts []float64
+		// want is the RTT of each call in order, -1 for no response
+		want         []float64
+		wantTerminal bool
+	}{
+		{name: "cycles", rtts: []float64{10, 20}, want: []float64{10, 20, 10, 20}},
+		{name: "failures", rtts: []float64{10, -1, -5}, want: []float64{10, -1, -1, 10}},
+		{name: "no values", wantTerminal: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewSimulatedPingProvider(tt.rtts)
+			if tt.wantTerminal {
+				_, err := provider.Ping(context.Background(), "example.com")
+				if err == nil || isRetryable(err) {
+					t.Errorf("Ping() = %v, want a terminal error", err)
+				}
+				return
+			}
+
+			for call, want := range tt.want {
+				result, err := provider.Ping(context.Background(), "example.com")
+				if want < 0 {
+					if !errors.Is(err, errNoResponse) {
+						t.Errorf("call %d: Ping() = %v, want no response", call+1, err)
+					}
+					continue
+				}
+				if err != nil || result.AvgRttMs != want || result.Recv != 1 {
+					t.Errorf("call %d: Ping() = %+v, %v, want %v ms", call+1, result, err, want)
+				}
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewSimulatedPingProvider([]float64{10}).Ping(ctx, "example.com"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Ping() after cancel = %v, want context.Canceled", err)
+	}
+}
+
+func TestSimulatedReports(t *testing.T) {
+	tests := []struct {
+		name string
+		rtts []float64
+		want []url.Values
+	}{
+		{
+			name: "up and down",
+			rtts: []float64{12.5, -1, 30},
+			want: []url.Values{
+				{"status": {"up"}, "ping": {"12.50"}},
+				{"status": {"down"}, "loss": {"100.00"}},
+				{"status": {"up"}, "ping": {"30.00"}},
+			},
+		},
+		{
+			name: "always down",
+			rtts: []float64{-1},
+			want: []url.Values{{"status": {"down"}}, {"status": {"down"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := make(chan url.Values, len(tt.want))
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				queries <- r.URL.Query()
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			cfg.PingProvider = nil
+			cfg.SimulatedRTTs = tt.rtts
+			cfg = targetConfig(cfg, resolveTargets(cfg)[0])
+			sinks, err := reportSinks(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rt := &targetRuntime{sinks: sinks}
+			for cycle, want := range tt.want {
+				_, _ = reportWithRetry(context.Background(), cfg, rt)
+				got := <-queries
+				for key := range want {
+					if got.Get(key) != want.Get(key) {
+						t.Errorf("cycle %d: %s = %q, want %q", cycle+1, key, got.Get(key), want.Get(key))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTargetConfigSimulatedRTTs(t *testing.T) {
+	custom := NewSimulatedPingProvider([]float64{99})
+
+	tests := []struct {
+		name     string
+		provider model.PingProvider
+		want     float64
+	}{
+		{name: "replayed per target", want: 10},
+		{name: "provider wins", provider: custom, want: 99},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.PingProvider = tt.provider
+			cfg.SimulatedRTTs = []float64{10, 20}
+			cfg.Targets = []model.MonitorTarget{
+				{Host: "a.example.com", ReportURL: "https://kuma.example.com/api/push/a"},
+				{Host: "b.example.com", ReportURL: "https://kuma.example.com/api/push/b"},
+			}
+
+			for _, target := range cfg.Targets {
+				result, err := targetConfig(cfg, target).PingProvider.Ping(context.Background(), target.Host)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.AvgRttMs != tt.want {
+					t.Errorf("%s first ping %v ms, want %v ms", target.Host, result.AvgRttMs, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewPingProvider(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	tests := []struct {
+		name    string
+		host    string
+		wantErr bool
+	}{
+		{name: "open port", host: listener.Addr().String()},
+		{name: "not host:port", host: "127.0.0.1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.CheckMode = checkModeTCP
+			// The built-in check must not loop back into the configured provider
+			cfg.PingProvider = failingProvider{err: errors.New("wrapped provider called")}
+
+			result, err := NewPingProvider(cfg).Ping(context.Background(), tt.host)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Ping() = %+v, want an error", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Recv != 1 || result.IP != "127.0.0.1" {
+				t.Errorf("Ping() = %+v, want one answer from 127.0.0.1", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package method
+
+import (
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reportLimiter caps the reports of all targets together, nil when
+// MaxReportsPerMinute is unset.
+var reportLimiter atomic.Pointer[tokenBucket]
+
+// tokenBucket allows bursts of up to capacity and refills at rate per second.
+type tokenBucket struct {
+	clock    model.Clock
+	mu       sync.Mutex
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(clock model.Clock, perMinute int) *tokenBucket {
+	return &tokenBucket{
+		clock:    clock,
+		capacity: float64(perMinute),
+		rate:     float64(perMinute) / 60,
+		tokens:   float64(perMinute),
+		last:     clock.Now(),
+	}
+}
+
+// allow takes a token if one is available.
+func (b *tokenBucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// takeReportToken takes the token of one heartbeat before it reaches the
+// sinks, so failover URLs and retries do not use more and the circuit
+// breaker never counts a drop as a failed endpoint. The drop is terminal,
+// retrying right away would only hit the limit again.
+func takeReportToken(cfg model.Config) error {
+	limiter := reportLimiter.Load()
+	if limiter == nil || limiter.allow() {
+		return nil
+	}
+
+	err := terminal(fmt.Errorf("report rate limit of %d per minute reached, dropping report", cfg.MaxReportsPerMinute))
+	cfg.Logger("WARN", err)
+	return err
+}
+
+// setReportRateLimit replaces the limiter with a full one for
+// cfg.MaxReportsPerMinute.
+func setReportRateLimit(cfg model.Config) {
+	if cfg.MaxReportsPerMinute <= 0 {
+		reportLimiter.Store(nil)
+		return
+	}
+	reportLimiter.Store(newTokenBucket(clockOf(cfg), cfg.MaxReportsPerMinute))
+}
@@ -0,0 +1,84 @@
+package method
+
+import (
+	"context"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReportRateLimitFlood(t *testing.T) {
+	tests := []struct {
+		name       string
+		perMinute  int
+		heartbeats int
+		reportURLs int
+		wantSent   int64
+	}{
+		{name: "unlimited", perMinute: 0, heartbeats: 10, reportURLs: 1, wantSent: 10},
+		{name: "drops beyond the burst", perMinute: 3, heartbeats: 10, reportURLs: 1, wantSent: 3},
+		{name: "failover does not take extra tokens", perMinute: 3, heartbeats: 10, reportURLs: 3, wantSent: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent atomic.Int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				sent.Add(1)
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			cfg.MaxReportsPerMinute = tt.perMinute
+			cfg.CircuitBreakerThreshold = 2
+			cfg.CircuitProbeInterval = time.Minute
+			for range tt.reportURLs - 1 {
+				cfg.ReportURLs = append(cfg.ReportURLs, srv.URL)
+			}
+			setReportRateLimit(cfg)
+			defer setReportRateLimit(model.Config{})
+
+			sinks, err := reportSinks(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rt := &targetRuntime{sinks: sinks}
+			var dropped int
+			for range tt.heartbeats {
+				if _, err := reportWithRetry(context.Background(), cfg, rt); err != nil {
+					dropped++
+				}
+			}
+
+			if got := sent.Load(); got != tt.wantSent {
+				t.Errorf("sent %d reports, want %d", got, tt.wantSent)
+			}
+			if want := tt.heartbeats - int(tt.wantSent); dropped != want {
+				t.Errorf("dropped %d heartbeats, want %d", dropped, want)
+			}
+			if state := sinks[0].(*httpReporter).breaker.state; state != circuitClosed {
+				t.Errorf("circuit is %s after dropped reports, want closed", state)
+			}
+		})
+	}
+}
+
+// testConfig is a valid single target config reporting to reportURL, with a
+// simulated 10 ms ping so no test touches the network to measure.
+func testConfig(reportURL string) model.Config {
+	return model.Config{
+		ReportURL:    reportURL + "/api/push/test",
+		PingHost:     "example.com",
+		PingCount:    1,
+		PingDeadline: time.Second,
+		ReportPeriod: time.Minute,
+		MaxRetries:   1,
+		HTTPTimeout:  5 * time.Second,
+		UseIPv4:      true,
+		Logger:       NopLogger,
+		PingProvider: NewSimulatedPingProvider([]float64{10}),
+	}
+}
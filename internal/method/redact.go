@@ -0,0 +1,60 @@
+package method
+
+import (
+	"net/url"
+	"strings"
+)
+
+const redacted = "****"
+
+var sensitiveParams = []string{"token", "key", "secret", "password", "auth", "signature"}
+
+// RedactURL masks the Uptime Kuma push token, credentials and sensitive query
+// parameters so the URL can be logged safely.
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	r := *u
+
+	if r.User != nil {
+		r.User = url.User(r.User.Username())
+	}
+
+	// "/api/push/<token>", redacted in the escaped path so the mask is not
+	// escaped in turn
+	segments := strings.Split(r.EscapedPath(), "/")
+	for i := 0; i < len(segments)-1; i++ {
+		if segments[i] == "push" && segments[i+1] != "" {
+			segments[i+1] = redacted
+		}
+	}
+	r.RawPath = strings.Join(segments, "/")
+	r.Path, _ = url.PathUnescape(r.RawPath)
+
+	if r.RawQuery != "" {
+		query := r.Query()
+		for key := range query {
+			lower := strings.ToLower(key)
+			for _, sensitive := range sensitiveParams {
+				if strings.Contains(lower, sensitive) {
+					query.Set(key, redacted)
+					break
+				}
+			}
+		}
+		r.RawQuery = strings.ReplaceAll(query.Encode(), url.QueryEscape(redacted), redacted)
+	}
+
+	return r.String()
+}
+
+// RedactURLString is RedactURL for raw strings, masking everything on parse failure.
+func RedactURLString(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+
+	return RedactURL(u)
+}
@@ -1,96 +1,664 @@
 package method
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"git.ghink.net/ghink/kuma-repoter/internal/version"
 	"github.com/go-ping/ping"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"os/exec"
-	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-func reportWithRetry(ctx context.Context, cfg model.Config) error {
+const (
+	statusUp   = "up"
+	statusDown = "down"
+)
+
+const (
+	protocolKuma         = "kuma"
+	protocolHealthchecks = "healthchecks"
+)
+
+const (
+	transportHTTP      = "http"
+	transportWebSocket = "websocket"
+
+	checkModeICMP = "icmp"
+	checkModeTCP  = "tcp"
+	checkModeHTTP = "http"
+)
+
+// targetRuntime is the state a target keeps across report cycles.
+type targetRuntime struct {
+	// name keys the target in the state file, PingHost when empty
+	name   string
+	sinks  []model.Reporter
+	window *rttWindow
+	// badCycles counts consecutive failed or lossy cycles for FlapThreshold
+	badCycles atomic.Int64
+	// lastFinished is when the last cycle ended, in Unix nanoseconds
+	lastFinished atomic.Int64
+}
+
+// newTargetRuntime returns the runtime of a target reporting to sinks. With
+// the runtime of the target before a reload as prev, it carries over the
+// flap count, the smoothing window when its size did not change, and the
+// failover and circuit state of the push sink.
+func newTargetRuntime(cfg model.Config, sinks []model.Reporter, prev *targetRuntime) *targetRuntime {
+	rt := &targetRuntime{sinks: sinks}
+	if cfg.SmoothingWindow > 1 {
+		rt.window = newRttWindow(cfg.SmoothingWindow)
+	}
+	if prev == nil {
+		return rt
+	}
+
+	rt.badCycles.Store(prev.badCycles.Load())
+	rt.lastFinished.Store(prev.lastFinished.Load())
+	if rt.window != nil && prev.window != nil && len(prev.window.samples) == cfg.SmoothingWindow {
+		rt.window = prev.window
+	}
+	if next, ok := sinks[0].(*httpReporter); ok {
+		if old, ok := prev.sinks[0].(*httpReporter); ok {
+			next.inherit(old)
+		}
+	}
+
+	return rt
+}
+
+// reportWithRetry measures the target and sends the heartbeat to every sink.
+// Only the primary sink is retried and decides the outcome, the others are
+// best effort. The returned error is the one of the outcome in res.
+func reportWithRetry(ctx context.Context, cfg model.Config, rt *targetRuntime) (res model.ReportResult, err error) {
+	sinks := rt.sinks
+	ctx, endCycle := startCycleSpan(ctx, cfg)
+
+	status := statusDown
+	var result model.PingResult
+	var attempts int
+	defer func() {
+		res = model.ReportResult{
+			Host:     cfg.PingHost,
+			Status:   status,
+			Success:  err == nil,
+			Result:   result,
+			Attempts: attempts,
+			Err:      err,
+			Time:     clockOf(cfg).Now(),
+		}
+		recordReport(cfg, err)
+		endCycle(res)
+		if ctx.Err() == nil {
+			writeState(cfg, rt.name, status, result, err)
+			notifyResult(cfg, res)
+		}
+	}()
+
+	// Retries of the ping and the push share one time budget
+	var retryDeadline time.Time
+	if cfg.MaxRetryDuration > 0 {
+		retryDeadline = clockOf(cfg).Now().Add(cfg.MaxRetryDuration)
+	}
+
+	pingCtx, endPing := startSpan(ctx, "ping")
+	result, attempts, err = pingWithRetry(pingCtx, cfg, retryDeadline)
+	endPing(err)
+	if err == nil {
+		recordPing(cfg, result)
+		if cfg.LossDownThreshold > 0 && result.PacketLoss > cfg.LossDownThreshold {
+			err = fmt.Errorf("packet loss %.0f%% exceeds %.0f%%", result.PacketLoss, cfg.LossDownThreshold)
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return res, err
+		}
+		if errors.Is(err, errNoResponse) {
+			result.PacketLoss = 100
+		}
+
+		// Debounce: only report down once enough consecutive cycles were bad
+		if bad := rt.badCycles.Add(1); bad < int64(cfg.FlapThreshold) {
+			cfg.Logger("WARN", fmt.Sprintf("Bad cycle %d/%d, holding off the report: %v", bad, cfg.FlapThreshold, err))
+			return res, err
+		}
+
+		if limitErr := takeReportToken(cfg); limitErr != nil {
+			return res, errors.Join(err, limitErr)
+		}
+		hb := model.Heartbeat{ID: randomHex(16), Host: cfg.PingHost, Status: statusDown, Msg: err.Error(), Result: result}
+		reportCtx, endReport := startSpan(ctx, "report")
+		sendErr := sinks[0].Report(reportCtx, hb)
+		endReport(sendErr)
+		if sendErr != nil {
+			cfg.Logger("ERROR", fmt.Errorf("down report failed: %w", sendErr))
+		} else {
+			cfg.Logger("WARN", "Reported down: ", err)
+		}
+		reportSecondary(ctx, cfg, sinks[1:], hb)
+		return res, err
+	}
+	rt.badCycles.Store(0)
+	status = statusUp
+
+	if rt.window != nil {
+		raw := result.AvgRttMs
+		p50, p95 := rt.window.add(raw)
+		result.AvgRttMs = p50
+		cfg.Logger("DEBUG", "Ping raw: ", millis(raw), ", smoothed p50: ", millis(p50), ", p95: ", millis(p95))
+	}
+
+	msg := renderStatusMessage(cfg, result)
+	if cfg.DegradedLossThreshold > 0 && result.PacketLoss > cfg.DegradedLossThreshold {
+		// Uptime Kuma only knows up and down, so degraded is an up beat with a note
+		msg = fmt.Sprintf("%s (degraded: %.0f%% packet loss)", msg, result.PacketLoss)
+		cfg.Logger("WARN", fmt.Sprintf("Degraded: %.0f%% packet loss", result.PacketLoss))
+	}
+
+	if result.Fallback {
+		msg += " (tcp fallback)"
+	}
+
+	if err = takeReportToken(cfg); err != nil {
+		return res, err
+	}
+	hb := model.Heartbeat{ID: randomHex(16), Host: cfg.PingHost, Status: statusUp, Msg: msg, Result: result}
+	reportCtx, endReport := startSpan(ctx, "report")
+	err = sendWithRetry(reportCtx, cfg, sinks[0], hb, retryDeadline)
+	endReport(err)
+	reportSecondary(ctx, cfg, sinks[1:], hb)
+	if err != nil {
+		return res, err
+	}
+
+	reportSucceeded.Store(true)
+	cfg.Logger("INFO", fmt.Sprintf("Report successful! Ping: %.2f ms, Loss: %.0f%%", result.AvgRttMs, result.PacketLoss))
+	return res, nil
+}
+
+// notifyResult passes the cycle outcome to OnResult, a panicking callback is
+// logged instead of taking the daemon down.
+func notifyResult(cfg model.Config, res model.ReportResult) {
+	if cfg.OnResult == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			cfg.Logger("ERROR", "OnResult callback panicked: ", r)
+		}
+	}()
+
+	cfg.OnResult(res)
+}
+
+func reportSecondary(ctx context.Context, cfg model.Config, sinks []model.Reporter, hb model.Heartbeat) {
+	for _, sink := range sinks {
+		if err := sink.Report(ctx, hb); err != nil {
+			cfg.Logger("WARN", "Secondary report failed: ", err)
+		}
+	}
+}
+
+// pingWithRetry measures the target, retrying up to MaxRetries times, and
+// returns the number of attempts made. No retry starts past a non-zero
+// deadline. The target is resolved once and the addresses reused by retries.
+func pingWithRetry(ctx context.Context, cfg model.Config, deadline time.Time) (model.PingResult, int, error) {
 	var lastErr error
+	var ips []string
 
 	for attempt := 1; attempt <= cfg.MaxRetries; attempt++ {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			pingTime, err := getPingTime(cfg)
-			if err != nil {
-				Logger("ERROR", fmt.Errorf("ping failed (attempt %d/%d): %w", attempt, cfg.MaxRetries, err).Error())
-				time.Sleep(cfg.RetryDelay)
-				continue
+		if attempt > 1 {
+			if !retryBudgetLeft(cfg, deadline) {
+				return model.PingResult{}, attempt - 1, lastErr
 			}
+			if err := sleepContext(ctx, clockOf(cfg), cfg.RetryDelay); err != nil {
+				return model.PingResult{}, attempt - 1, errors.Join(err, lastErr)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return model.PingResult{}, attempt - 1, errors.Join(err, lastErr)
+		}
+
+		result, resolved, err := measure(ctx, cfg, ips)
+		if err == nil {
+			return result, attempt, nil
+		}
+		ips = resolved
+		lastErr = fmt.Errorf("ping failed (attempt %d/%d): %w", attempt, cfg.MaxRetries, err)
+		cfg.Logger("ERROR", lastErr)
+		if !isRetryable(err) {
+			return model.PingResult{}, attempt, lastErr
+		}
+	}
+
+	return model.PingResult{}, cfg.MaxRetries, lastErr
+}
+
+// sendWithRetry retries only the report of an already measured result, so a
+// flaky push endpoint does not cost a fresh ping.
+func sendWithRetry(ctx context.Context, cfg model.Config, sink model.Reporter, hb model.Heartbeat, deadline time.Time) error {
+	var lastErr error
+
+	// A repeated POST may count twice unless the server can deduplicate it
+	maxAttempts := cfg.MaxRetries
+	if !sendIsIdempotent(cfg) {
+		maxAttempts = 1
+	}
 
-			if err = sendReport(cfg, pingTime); err != nil {
-				Logger("ERROR", fmt.Errorf("report failed (attempt %d/%d): %w", attempt, cfg.MaxRetries, err))
-				time.Sleep(cfg.RetryDelay)
-				continue
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			if !retryBudgetLeft(cfg, deadline) {
+				return lastErr
+			}
+			if err := sleepContext(ctx, clockOf(cfg), cfg.RetryDelay); err != nil {
+				return errors.Join(err, lastErr)
 			}
+		}
+		if err := ctx.Err(); err != nil {
+			return errors.Join(err, lastErr)
+		}
 
-			Logger("INFO", fmt.Sprintf("Report successful! Ping: %.2f ms", pingTime))
+		err := sink.Report(ctx, hb)
+		if err == nil {
 			return nil
 		}
+		lastErr = fmt.Errorf("report failed (attempt %d/%d): %w", attempt, maxAttempts, err)
+		cfg.Logger("ERROR", lastErr)
+		if !isRetryable(err) {
+			break
+		}
 	}
 
 	return lastErr
 }
 
-func getPingTime(cfg model.Config) (float64, error) {
-	ips, err := resolveIP(cfg.PingHost, cfg.UseIPv4, cfg.UseIPv6)
-	if err != nil {
-		err = fmt.Errorf("DNS resolution failed: %w", err)
-		Logger("ERROR")
-		return 0, err
+// sendIsIdempotent reports whether a failed push may be repeated: GET
+// reports are, POST ones only when they carry an Idempotency-Key.
+func sendIsIdempotent(cfg model.Config) bool {
+	return strings.ToUpper(cfg.ReportMethod) != http.MethodPost || cfg.IdempotencyKey
+}
+
+// retryBudgetLeft reports whether a retry after RetryDelay still starts
+// before deadline. A zero deadline is unlimited.
+func retryBudgetLeft(cfg model.Config, deadline time.Time) bool {
+	if deadline.IsZero() || clockOf(cfg).Now().Add(cfg.RetryDelay).Before(deadline) {
+		return true
 	}
 
-	if len(ips) == 0 {
-		err = fmt.Errorf("no valid IP addresses found for %s", cfg.PingHost)
-		Logger("ERROR", err)
-		return 0, err
+	cfg.Logger("WARN", "Retry budget of ", cfg.MaxRetryDuration, " exhausted, giving up")
+	return false
+}
+
+// sleepContext waits for d on clock, returning early with ctx.Err() if ctx
+// is cancelled.
+func sleepContext(ctx context.Context, clock model.Clock, d time.Duration) error {
+	select {
+	case <-clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	var lastErr error
-	for _, ip := range ips {
-		var pingTime float64
+// Check runs a single measurement without reporting it. Nothing is logged
+// unless cfg.Logger is set. Unset fields get the binary's defaults: one
+// packet, a 10s deadline and IPv4 when neither family is enabled.
+func Check(ctx context.Context, cfg model.Config) (model.PingResult, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = NopLogger
+	}
+	cfg.PingCount = max(cfg.PingCount, 1)
+	if pingDeadline(cfg) <= 0 {
+		cfg.PingDeadline = defaultCheckDeadline
+	}
+	if !cfg.UseIPv4 && !cfg.UseIPv6 {
+		cfg.UseIPv4 = true
+	}
+
+	return getPingTime(ctx, cfg)
+}
+
+const defaultCheckDeadline = 10 * time.Second
+
+// getPingTime measures the target once according to CheckMode.
+func getPingTime(ctx context.Context, cfg model.Config) (model.PingResult, error) {
+	result, _, err := measure(ctx, cfg, nil)
+	return result, err
+}
+
+// measure runs one check against ips, resolving the target first when ips
+// is empty. The addresses used are returned so retries within a cycle, like
+// the TCP fallback, target the same ones without another lookup.
+func measure(ctx context.Context, cfg model.Config, ips []string) (model.PingResult, []string, error) {
+	if hosts := splitHosts(cfg.PingHost); len(hosts) > 1 {
+		result, err := pingHosts(ctx, cfg, hosts)
+		return result, nil, err
+	}
+	if cfg.PingProvider != nil {
+		result, err := cfg.PingProvider.Ping(ctx, cfg.PingHost)
+		if err != nil {
+			cfg.Logger("ERROR", err)
+		}
+		return result, nil, err
+	}
+
+	host, port := cfg.PingHost, ""
+	switch cfg.CheckMode {
+	case "", checkModeICMP:
+	case checkModeTCP:
 		var err error
+		if host, port, err = net.SplitHostPort(cfg.PingHost); err != nil {
+			err = terminal(fmt.Errorf("tcp check needs host:port: %w", err))
+			cfg.Logger("ERROR", err)
+			return model.PingResult{}, nil, err
+		}
+	case checkModeHTTP:
+		result, err := pingWithHTTP(ctx, cfg)
+		return result, nil, err
+	default:
+		err := terminal(fmt.Errorf("unknown check mode %q", cfg.CheckMode))
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, nil, err
+	}
 
-		if cfg.UseSystemPing {
-			pingTime, err = pingWithSystem(ip, cfg.PingCount, cfg.PingTimeout)
-		} else {
-			pingTime, err = pingWithGoPing(ip, cfg.PingCount, cfg.PingTimeout)
+	if cfg.DualStack {
+		result, err := pingDualStack(ctx, cfg, host, port)
+		return result, nil, err
+	}
+
+	if len(ips) == 0 {
+		var err error
+		if ips, err = resolveIP(ctx, cfg, host); err != nil {
+			err = classifyDNSError(fmt.Errorf("DNS resolution failed: %w", err))
+			cfg.Logger("ERROR", err)
+			return model.PingResult{}, nil, err
 		}
+		if len(ips) == 0 {
+			err = terminal(fmt.Errorf("no valid IP addresses found for %s", cfg.PingHost))
+			cfg.Logger("ERROR", err)
+			return model.PingResult{}, nil, err
+		}
+	}
+
+	result, err := pingIPs(ctx, cfg, ips, port)
+	if err == nil || port != "" || !cfg.FallbackToTCP || ctx.Err() != nil {
+		return result, ips, err
+	}
+
+	// ICMP is often filtered while the service itself is reachable
+	port = strconv.Itoa(cfg.FallbackTCPPort)
+	cfg.Logger("WARN", "ICMP ping failed, falling back to TCP port ", port, ": ", err)
+	tcpCfg := cfg
+	tcpCfg.CheckMode = checkModeTCP
+	result, tcpErr := pingIPs(ctx, tcpCfg, ips, port)
+	if tcpErr != nil {
+		return model.PingResult{}, ips, errors.Join(err, fmt.Errorf("tcp fallback: %w", tcpErr))
+	}
+	result.Fallback = true
+	cfg.Logger("INFO", fmt.Sprintf("TCP fallback succeeded, reporting connect time %.2f ms", result.AvgRttMs))
+
+	return result, ips, nil
+}
+
+// pingIPs pings the resolved addresses, stopping at the first responder
+// unless PingAllIPs is set.
+func pingIPs(ctx context.Context, cfg model.Config, ips []string, port string) (model.PingResult, error) {
+	if cfg.PingAllIPs {
+		return pingAllIPs(ctx, cfg, ips, port)
+	}
 
+	var lastErr error
+	for _, ip := range ips {
+		result, err := pingIP(ctx, cfg, ip, port)
 		if err == nil {
-			return pingTime, nil
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return model.PingResult{}, ctx.Err()
 		}
 		lastErr = err
-		Logger("ERROR", "Ping failed for ", ip, ": ", err, ", trying next IP")
+		cfg.Logger("ERROR", "Ping failed for ", ip, ": ", err, ", trying next IP")
+	}
+
+	return model.PingResult{}, lastErr
+}
+
+// splitHosts parses a comma separated PingHost into its hosts.
+func splitHosts(pingHost string) []string {
+	var hosts []string
+	for _, host := range strings.Split(pingHost, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
 	}
 
-	return 0, lastErr
+	return hosts
 }
 
-func resolveIP(host string, useIPv4, useIPv6 bool) ([]string, error) {
-	ips, err := net.LookupIP(host)
+// pingHosts measures every host of a pool and reports the fastest, the
+// slowest or, with PingHostAggregate "avg", the mean of those that answered.
+// The pool is only down when no host answers, the others count as lost
+// packets in the pool's packet loss.
+func pingHosts(ctx context.Context, cfg model.Config, hosts []string) (model.PingResult, error) {
+	var results []model.PingResult
+	var errs []error
+	lost := 0
+	for _, host := range hosts {
+		hostCfg := cfg
+		hostCfg.PingHost = host
+		result, _, err := measure(ctx, hostCfg, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return model.PingResult{}, ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+			lost += cfg.PingCount
+			continue
+		}
+		results = append(results, result)
+	}
+
+	if len(results) == 0 {
+		return model.PingResult{}, errors.Join(errs...)
+	}
+
+	fastest, slowest := results[0], results[0]
+	for _, result := range results[1:] {
+		if result.AvgRttMs < fastest.AvgRttMs {
+			fastest = result
+		}
+		if result.AvgRttMs > slowest.AvgRttMs {
+			slowest = result
+		}
+	}
+
+	var pool model.PingResult
+	switch cfg.PingHostAggregate {
+	case "max":
+		pool = slowest
+	case "avg":
+		pool.MinRttMs = fastest.MinRttMs
+		for _, result := range results {
+			pool.AvgRttMs += result.AvgRttMs / float64(len(results))
+			pool.MinRttMs = min(pool.MinRttMs, result.MinRttMs)
+			pool.MaxRttMs = max(pool.MaxRttMs, result.MaxRttMs)
+			pool.Jitter += result.Jitter / float64(len(results))
+		}
+		pool.IP = fastest.IP
+	default:
+		pool = fastest
+	}
+
+	pool.Sent, pool.Recv = lost, 0
+	for _, result := range results {
+		pool.Sent += result.Sent
+		pool.Recv += result.Recv
+	}
+	if pool.Sent > 0 {
+		pool.PacketLoss = float64(pool.Sent-pool.Recv) / float64(pool.Sent) * 100
+	}
+	cfg.Logger("DEBUG", len(results), " of ", len(hosts), " hosts answered, ", orDefault(cfg.PingHostAggregate, "min"), " RTT ", millis(pool.AvgRttMs))
+
+	return pool, nil
+}
+
+func pingIP(ctx context.Context, cfg model.Config, ip, port string) (model.PingResult, error) {
+	var result model.PingResult
+	var err error
+
+	switch {
+	case cfg.CheckMode == checkModeTCP:
+		result, err = pingWithTCP(ctx, cfg, ip, port)
+	case cfg.UseSystemPing:
+		result, err = pingWithSystem(ctx, cfg, ip)
+		if errors.Is(err, exec.ErrNotFound) && cfg.AutoFallbackPing {
+			cfg.Logger("WARN", "System ping not installed, using the built-in ping")
+			result, err = pingWithGoPing(ctx, cfg, ip)
+		}
+	default:
+		result, err = pingWithGoPing(ctx, cfg, ip)
+	}
+	result.IP = ip
+
+	return result, err
+}
+
+// pingAllIPs pings every address and reports the fastest one, or the mean
+// across all responding addresses when PingAllIPsAggregate is "mean".
+func pingAllIPs(ctx context.Context, cfg model.Config, ips []string, port string) (model.PingResult, error) {
+	var results []model.PingResult
+	var lastErr error
+	for _, ip := range ips {
+		result, err := pingIP(ctx, cfg, ip, port)
+		if err != nil {
+			if ctx.Err() != nil {
+				return model.PingResult{}, ctx.Err()
+			}
+			lastErr = err
+			cfg.Logger("ERROR", "Ping failed for ", ip, ": ", err)
+			continue
+		}
+		results = append(results, result)
+	}
+
+	if len(results) == 0 {
+		return model.PingResult{}, lastErr
+	}
+
+	best := results[0]
+	for _, result := range results[1:] {
+		if result.AvgRttMs < best.AvgRttMs {
+			best = result
+		}
+	}
+
+	if cfg.PingAllIPsAggregate != "mean" {
+		cfg.Logger("INFO", fmt.Sprintf("Fastest of %d addresses: %s (%.2f ms)", len(results), best.IP, best.AvgRttMs))
+		return best, nil
+	}
+
+	var mean model.PingResult
+	mean.MinRttMs = results[0].MinRttMs
+	for _, result := range results {
+		mean.AvgRttMs += result.AvgRttMs / float64(len(results))
+		mean.MinRttMs = min(mean.MinRttMs, result.MinRttMs)
+		mean.MaxRttMs = max(mean.MaxRttMs, result.MaxRttMs)
+		mean.PacketLoss += result.PacketLoss / float64(len(results))
+		mean.Jitter += result.Jitter / float64(len(results))
+		mean.Sent += result.Sent
+		mean.Recv += result.Recv
+	}
+	mean.IP = best.IP
+	cfg.Logger("INFO", fmt.Sprintf("Mean of %d addresses: %.2f ms, fastest %s (%.2f ms)", len(results), mean.AvgRttMs, best.IP, best.AvgRttMs))
+
+	return mean, nil
+}
+
+// pingDualStack pings the first IPv4 and the first IPv6 address of host and
+// keeps both results. The IPv4 one is reported as the main result when it
+// answered. Only when neither family answers is the target down.
+func pingDualStack(ctx context.Context, cfg model.Config, host, port string) (model.PingResult, error) {
+	ips, err := lookupIP(ctx, cfg, host)
+	if err != nil {
+		err = classifyDNSError(fmt.Errorf("DNS resolution failed: %w", err))
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, err
+	}
+
+	var v4, v6 string
+	for _, ip := range ips {
+		if ip.To4() != nil && v4 == "" {
+			v4 = ip.String()
+		} else if ip.To4() == nil && v6 == "" {
+			v6 = ip.String()
+		}
+	}
+
+	family := func(name, ip string) (*model.PingResult, error) {
+		if ip == "" {
+			err := fmt.Errorf("no %s address for %s", name, host)
+			cfg.Logger("WARN", err)
+			return nil, err
+		}
+		result, err := pingIP(ctx, cfg, ip, port)
+		if err != nil {
+			cfg.Logger("WARN", name, " ping failed for ", ip, ": ", err)
+			return nil, err
+		}
+		return &result, nil
+	}
+
+	resultV4, errV4 := family("IPv4", v4)
+	if ctx.Err() != nil {
+		return model.PingResult{}, ctx.Err()
+	}
+	resultV6, errV6 := family("IPv6", v6)
+	if ctx.Err() != nil {
+		return model.PingResult{}, ctx.Err()
+	}
+
+	var result model.PingResult
+	switch {
+	case resultV4 != nil:
+		result = *resultV4
+	case resultV6 != nil:
+		result = *resultV6
+	default:
+		return model.PingResult{}, errors.Join(errV4, errV6)
+	}
+	result.V4, result.V6 = resultV4, resultV6
+
+	return result, nil
+}
+
+func resolveIP(ctx context.Context, cfg model.Config, host string) ([]string, error) {
+	ctx, endResolve := startSpan(ctx, "resolve")
+	ips, err := lookupIP(ctx, cfg, host)
+	endResolve(err)
 	if err != nil {
 		return nil, err
 	}
 
 	var validIPs []string
 	for _, ip := range ips {
-		if useIPv4 && ip.To4() != nil {
+		if cfg.UseIPv4 && ip.To4() != nil {
 			validIPs = append(validIPs, ip.String())
-		} else if useIPv6 && ip.To4() == nil {
+		} else if cfg.UseIPv6 && ip.To4() == nil {
 			validIPs = append(validIPs, ip.String())
 		}
 	}
@@ -98,134 +666,318 @@ func resolveIP(host string, useIPv4, useIPv6 bool) ([]string, error) {
 	return validIPs, nil
 }
 
-func pingWithGoPing(ip string, count int, timeout time.Duration) (float64, error) {
-	pinger, err := ping.NewPinger(ip)
+// errNoResponse marks a ping that lost every packet. It fails the check like
+// any other error, so the cycle is reported down instead of skipped.
+var errNoResponse = errors.New("no response")
+
+func pingWithGoPing(ctx context.Context, cfg model.Config, ip string) (model.PingResult, error) {
+	pinger, err := newPinger(cfg, ip)
 	if err != nil {
-		err = fmt.Errorf("pinger creation failed: %w", err)
-		Logger("ERROR", err)
-		return 0, err
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, err
 	}
 
-	pinger.Count = count
-	pinger.Timeout = timeout
-	pinger.SetPrivileged(true)
+	var rtts []float64
+	if cfg.TrimOutliers {
+		pinger.OnRecv = func(pkt *ping.Packet) {
+			rtts = append(rtts, pkt.Rtt.Seconds()*1000)
+		}
+	}
+
+	// go-ping has no context support, stop the pinger when ctx is cancelled
+	stop := context.AfterFunc(ctx, pinger.Stop)
+	defer stop()
 
 	if err := pinger.Run(); err != nil {
 		err = fmt.Errorf("ping failed: %w", err)
-		Logger("ERROR", err)
-		return 0, err
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return model.PingResult{}, err
 	}
 
 	stats := pinger.Statistics()
 	if stats.PacketsRecv == 0 {
-		err = fmt.Errorf("no response from %s", ip)
-		Logger("ERROR", err)
-		return 0, err
+		err := fmt.Errorf("%w from %s", errNoResponse, ip)
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, err
+	}
+
+	result := model.PingResult{
+		AvgRttMs:   stats.AvgRtt.Seconds() * 1000,
+		MinRttMs:   stats.MinRtt.Seconds() * 1000,
+		MaxRttMs:   stats.MaxRtt.Seconds() * 1000,
+		PacketLoss: stats.PacketLoss,
+		Jitter:     stats.StdDevRtt.Seconds() * 1000,
+		Sent:       stats.PacketsSent,
+		Recv:       stats.PacketsRecv,
+	}
+	if cfg.TrimOutliers {
+		result.AvgRttMs = trimmedMean(rtts)
 	}
 
-	return stats.AvgRtt.Seconds() * 1000, nil
+	return result, nil
 }
 
-func pingWithSystem(ip string, count int, timeout time.Duration) (float64, error) {
-	cmdName := "ping"
-	var args []string
+// newPinger returns a go-ping pinger for ip configured by cfg.
+func newPinger(cfg model.Config, ip string) (*ping.Pinger, error) {
+	// Pin the address family so v6 targets get an ICMPv6 socket
+	pinger := ping.New(ip)
+	if isIPv6(ip) {
+		pinger.SetNetwork("ip6")
+	} else {
+		pinger.SetNetwork("ip4")
+	}
 
-	switch runtime.GOOS {
-	case "darwin": // macOS
-		args = []string{"-c", strconv.Itoa(count), "-t", strconv.Itoa(int(timeout.Seconds())), ip}
-	case "windows":
-		args = []string{"-n", strconv.Itoa(count), "-w", strconv.Itoa(int(timeout.Milliseconds())), ip}
-	default: // Linux and other unix-like system
-		args = []string{"-c", strconv.Itoa(count), "-W", strconv.Itoa(int(timeout.Seconds())), ip}
+	if err := pinger.Resolve(); err != nil {
+		return nil, fmt.Errorf("pinger creation failed: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout+2*time.Second)
-	defer cancel()
+	// go-ping panics on a non-positive timeout
+	if pingDeadline(cfg) <= 0 {
+		return nil, terminal(errors.New("ping deadline must be positive"))
+	}
 
-	cmd := exec.CommandContext(ctx, cmdName, args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		err = fmt.Errorf("system ping command failed: %w, output: %s", err, string(output))
-		Logger("ERROR", err)
-		return 0, err
+	pinger.Count = cfg.PingCount
+	pinger.Timeout = pingDeadline(cfg)
+	if cfg.PingInterval > 0 {
+		pinger.Interval = cfg.PingInterval
 	}
+	if cfg.PingPacketSize > 0 {
+		pinger.Size = cfg.PingPacketSize
+	}
+	pinger.SetPrivileged(cfg.PrivilegedPing)
 
-	return parseSystemPingOutput(string(output))
+	return pinger, nil
 }
 
-func parseSystemPingOutput(output string) (float64, error) {
-	lines := strings.Split(output, "\n")
+type reportPayload struct {
+	Status string `json:"status"`
+	Msg    string `json:"msg"`
+	// Ping is nil with OmitPingValue
+	Ping   *float64 `json:"ping,omitempty"`
+	Loss   float64  `json:"loss"`
+	Jitter float64  `json:"jitter"`
+	// The per family fields are only set with DualStack
+	StatusV4 string  `json:"status_v4,omitempty"`
+	StatusV6 string  `json:"status_v6,omitempty"`
+	PingV4   float64 `json:"ping_v4,omitempty"`
+	PingV6   float64 `json:"ping_v6,omitempty"`
+	// Source and Version are only set with IncludeMetadata
+	Source  string `json:"source,omitempty"`
+	Version string `json:"version,omitempty"`
+}
 
-	for i := len(lines) - 1; i >= 0; i-- {
-		line := lines[i]
+// addFamilyParams adds ping_<family> and status_<family> for DualStack,
+// a family without result is down.
+func addFamilyParams(cfg model.Config, params url.Values, family string, result *model.PingResult) {
+	if result == nil {
+		params.Add("status_"+family, statusDown)
+		return
+	}
+	params.Add("status_"+family, statusUp)
+	if !cfg.OmitPingValue {
+		params.Add("ping_"+family, fmt.Sprintf("%.2f", result.AvgRttMs))
+	}
+}
 
-		// "round-trip min/avg/max/stddev = 1.234/2.345/3.456/0.123 ms"
-		if strings.Contains(line, "round-trip") || strings.Contains(line, "rtt") {
-			parts := strings.Fields(line)
-			for _, part := range parts {
-				if strings.Contains(part, "/") {
-					stats := strings.Split(part, "/")
-					if len(stats) >= 4 {
-						avg, err := strconv.ParseFloat(stats[1], 64)
-						if err == nil {
-							return avg, nil
-						}
-					}
-				}
+func newReportPayload(cfg model.Config, status, msg string, result model.PingResult) reportPayload {
+	payload := reportPayload{
+		Status: status,
+		Msg:    msg,
+		Loss:   math.Round(result.PacketLoss*100) / 100,
+		Jitter: math.Round(result.Jitter*100) / 100,
+	}
+	if !cfg.OmitPingValue {
+		ping := math.Round(result.AvgRttMs*100) / 100
+		payload.Ping = &ping
+	}
+	if cfg.DualStack {
+		payload.StatusV4, payload.StatusV6 = statusDown, statusDown
+		if result.V4 != nil {
+			payload.StatusV4 = statusUp
+			if !cfg.OmitPingValue {
+				payload.PingV4 = math.Round(result.V4.AvgRttMs*100) / 100
 			}
 		}
-
-		// "Minimum = 1ms, Maximum = 2ms, Average = 3ms"
-		if strings.Contains(line, "Average =") {
-			parts := strings.Fields(line)
-			for i, part := range parts {
-				if part == "Average" && i+2 < len(parts) {
-					avgStr := strings.TrimSuffix(parts[i+2], "ms")
-					avg, err := strconv.ParseFloat(avgStr, 64)
-					if err == nil {
-						return avg, nil
-					}
-				}
+		if result.V6 != nil {
+			payload.StatusV6 = statusUp
+			if !cfg.OmitPingValue {
+				payload.PingV6 = math.Round(result.V6.AvgRttMs*100) / 100
 			}
 		}
 	}
+	if cfg.IncludeMetadata {
+		payload.Source = sourceName(cfg)
+		payload.Version = version.Version
+	}
 
-	err := fmt.Errorf("could not parse ping output: %s", output)
-	Logger("ERROR", err)
-	return 0, err
+	return payload
 }
 
-func sendReport(cfg model.Config, pingTime float64) error {
+func sendReport(ctx context.Context, cfg model.Config, client *http.Client, hb model.Heartbeat) error {
+	status, msg, result := hb.Status, hb.Msg, hb.Result
 	reportUrl, err := url.Parse(cfg.ReportURL)
 	if err != nil {
-		return fmt.Errorf("invalid URL: %w", err)
+		return terminal(fmt.Errorf("invalid URL: %w", err))
+	}
+
+	reportMethod := strings.ToUpper(orDefault(cfg.ReportMethod, http.MethodGet))
+	var req *http.Request
+	var body []byte
+	switch {
+	case cfg.ReportProtocol == protocolHealthchecks:
+		// Healthchecks.io: plain ping URL, "/fail" signals a failure. A POST
+		// body is kept as the ping's log
+		if status == statusDown {
+			reportUrl = reportUrl.JoinPath("fail")
+		}
+		if reportMethod != http.MethodPost {
+			req, err = http.NewRequestWithContext(ctx, http.MethodGet, reportUrl.String(), nil)
+			break
+		}
+
+		body, err = renderReportBody(cfg, orDefault(cfg.ReportBodyTemplate, defaultHealthchecksBody), status, msg, result)
+		if err != nil {
+			return terminal(err)
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, reportUrl.String(), bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+	case cfg.ReportProtocol != "" && cfg.ReportProtocol != protocolKuma:
+		return terminal(fmt.Errorf("unsupported report protocol %q", cfg.ReportProtocol))
+	case reportMethod == http.MethodGet:
+		names := cfg.ParamNames
+		params := url.Values{}
+		params.Add(orDefault(names.Status, "status"), status)
+		params.Add(orDefault(names.Msg, "msg"), msg)
+		if !cfg.OmitPingValue {
+			params.Add(orDefault(names.Ping, "ping"), fmt.Sprintf("%.2f", result.AvgRttMs))
+		}
+		params.Add(orDefault(names.Loss, "loss"), fmt.Sprintf("%.2f", result.PacketLoss))
+		params.Add(orDefault(names.Jitter, "jitter"), fmt.Sprintf("%.2f", result.Jitter))
+		if cfg.DualStack {
+			addFamilyParams(cfg, params, "v4", result.V4)
+			addFamilyParams(cfg, params, "v6", result.V6)
+		}
+		if cfg.IncludeMetadata {
+			params.Add("source", sourceName(cfg))
+			params.Add("version", version.Version)
+		}
+		reportUrl.RawQuery = params.Encode()
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, reportUrl.String(), nil)
+	case reportMethod == http.MethodPost && cfg.ReportBodyTemplate != "":
+		body, err = renderReportBody(cfg, cfg.ReportBodyTemplate, status, msg, result)
+		if err != nil {
+			return terminal(err)
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, reportUrl.String(), bytes.NewReader(body))
+		if err == nil {
+			// A Content-Type in ReportHeaders, set below, takes precedence
+			contentType := "text/plain; charset=utf-8"
+			if json.Valid(body) {
+				contentType = "application/json"
+			}
+			req.Header.Set("Content-Type", contentType)
+		}
+	case reportMethod == http.MethodPost:
+		body, err = json.Marshal(newReportPayload(cfg, status, msg, result))
+		if err != nil {
+			return terminal(fmt.Errorf("encode report: %w", err))
+		}
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, reportUrl.String(), bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	default:
+		return terminal(fmt.Errorf("unsupported report method %q", cfg.ReportMethod))
+	}
+	if err != nil {
+		return terminal(fmt.Errorf("build request: %w", err))
 	}
 
-	params := url.Values{}
-	params.Add("status", "up")
-	params.Add("msg", cfg.StatusMessage)
-	params.Add("ping", fmt.Sprintf("%.2f", pingTime))
-	reportUrl.RawQuery = params.Encode()
+	req.Header.Set("User-Agent", orDefault(cfg.UserAgent, "kuma-reporter/"+version.Version))
+	setTraceHeaders(cfg, req)
+	if cfg.IdempotencyKey && hb.ID != "" {
+		req.Header.Set("Idempotency-Key", hb.ID)
+	}
+	// Header values may hold credentials, so they are never logged
+	for key, value := range cfg.ReportHeaders {
+		req.Header.Set(key, value)
+	}
 
-	client := &http.Client{
-		Timeout: cfg.HTTPTimeout,
+	if cfg.DryRun {
+		cfg.Logger("INFO", "Dry run, not sending: ", req.Method, " ", RedactURL(req.URL), " ", string(body))
+		return nil
 	}
 
-	resp, err := client.Get(reportUrl.String())
+	resp, err := client.Do(req)
 	if err != nil {
-		err = fmt.Errorf("HTTP request failed: %w", err)
-		Logger("ERROR", err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = RedactURL(req.URL)
+		}
+		err = classifyNetError(fmt.Errorf("HTTP request failed: %w", err))
+		cfg.Logger("ERROR", err)
 		return err
 	}
 	defer func(Body io.ReadCloser) {
 		_ = Body.Close()
 	}(resp.Body)
 
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("unexpected status: %s", resp.Status)
-		Logger("ERROR", err)
+	accepted := cfg.AcceptableReportStatus
+	if len(accepted) == 0 {
+		accepted = []int{http.StatusOK}
+	}
+	if !slices.Contains(accepted, resp.StatusCode) {
+		// The body usually says why, e.g. "monitor not found"
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err = classifyStatus(resp.StatusCode, fmt.Errorf("unexpected status: %s: %s", resp.Status, strings.TrimSpace(string(reason))))
+		cfg.Logger("ERROR", err)
 		return err
 	}
+	cfg.Logger("DEBUG", "Report accepted with status ", resp.Status)
 
 	return nil
 }
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+
+	return value
+}
+
+// sourceName identifies this reporter, SourceName or the hostname.
+func sourceName(cfg model.Config) string {
+	if cfg.SourceName != "" {
+		return cfg.SourceName
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
+
+func renderReportBody(cfg model.Config, text, status, msg string, result model.PingResult) ([]byte, error) {
+	data := newMessageData(cfg, result)
+	data.Status = status
+	data.Msg = msg
+
+	body, err := renderTemplate(text, data)
+	if err != nil {
+		return nil, fmt.Errorf("render report body: %w", err)
+	}
+
+	return []byte(body), nil
+}
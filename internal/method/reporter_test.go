@@ -0,0 +1,912 @@
+package method
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"git.ghink.net/ghink/kuma-repoter/internal/version"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// captureServer records the report parameters of every request, the query
+// of a GET or the top-level keys of a JSON POST body.
+func captureServer(t *testing.T) (*httptest.Server, func() map[string]bool) {
+	t.Helper()
+
+	got := make(chan map[string]bool, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := map[string]bool{}
+		for key := range r.URL.Query() {
+			keys[key] = true
+		}
+		if r.Method == http.MethodPost {
+			var body map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&body); err == nil {
+				for key := range body {
+					keys[key] = true
+				}
+			}
+		}
+		got <- keys
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() map[string]bool {
+		select {
+		case keys := <-got:
+			return keys
+		default:
+			t.Fatal("no report received")
+			return nil
+		}
+	}
+}
+
+func TestSendReportPingValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		dualStack bool
+		omit      bool
+		want      map[string]bool
+	}{
+		{name: "get", method: http.MethodGet, want: map[string]bool{"ping": true}},
+		{name: "get omitted", method: http.MethodGet, omit: true, want: map[string]bool{"ping": false}},
+		{name: "get dual stack omitted", method: http.MethodGet, dualStack: true, omit: true,
+			want: map[string]bool{"ping": false, "ping_v4": false, "ping_v6": false, "status_v4": true}},
+		{name: "post", method: http.MethodPost, want: map[string]bool{"ping": true}},
+		{name: "post omitted", method: http.MethodPost, omit: true, want: map[string]bool{"ping": false, "loss": true}},
+		{name: "post dual stack omitted", method: http.MethodPost, dualStack: true, omit: true,
+			want: map[string]bool{"ping": false, "ping_v4": false, "ping_v6": false, "status_v6": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, received := captureServer(t)
+			cfg := testConfig(srv.URL)
+			cfg.ReportMethod = tt.method
+			cfg.DualStack = tt.dualStack
+			cfg.OmitPingValue = tt.omit
+
+			result := model.PingResult{AvgRttMs: 10, V4: &model.PingResult{AvgRttMs: 10}, V6: &model.PingResult{AvgRttMs: 12}}
+			hb := model.Heartbeat{Status: statusUp, Msg: "OK", Result: result}
+			if err := sendReport(context.Background(), cfg, srv.Client(), hb); err != nil {
+				t.Fatal(err)
+			}
+
+			keys := received()
+			for key, want := range tt.want {
+				if keys[key] != want {
+					t.Errorf("%s present = %t, want %t", key, keys[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestPingWithRetryCancelKeepsLastErr(t *testing.T) {
+	tests := []struct {
+		name         string
+		cancelFirst  bool
+		wantAttempts int
+		wantLastErr  bool
+	}{
+		{name: "cancelled before the first attempt", cancelFirst: true, wantAttempts: 0, wantLastErr: false},
+		{name: "cancelled during the retry delay", wantAttempts: 1, wantLastErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testEpoch)
+			cfg := testConfig("https://kuma.example.com")
+			cfg.Clock = clock
+			cfg.MaxRetries = 3
+			cfg.RetryDelay = time.Minute
+			cfg.PingProvider = NewSimulatedPingProvider([]float64{-1})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelFirst {
+				cancel()
+			}
+			type outcome struct {
+				attempts int
+				err      error
+			}
+			done := make(chan outcome, 1)
+			go func() {
+				_, attempts, err := pingWithRetry(ctx, cfg, time.Time{})
+				done <- outcome{attempts, err}
+			}()
+			if !tt.cancelFirst {
+				waitFor(t, func() bool { return clock.Waiters() == 1 })
+				cancel()
+			}
+
+			got := <-done
+			if got.attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got.attempts, tt.wantAttempts)
+			}
+			if !errors.Is(got.err, context.Canceled) {
+				t.Errorf("err = %v, want context.Canceled", got.err)
+			}
+			if errors.Is(got.err, errNoResponse) != tt.wantLastErr {
+				t.Errorf("err = %v, want the last ping error %t", got.err, tt.wantLastErr)
+			}
+		})
+	}
+}
+
+func TestSleepContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		advance bool
+		cancel  bool
+		want    error
+	}{
+		{name: "delay elapses", advance: true, want: nil},
+		{name: "cancelled", cancel: true, want: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(testEpoch)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- sleepContext(ctx, clock, time.Minute)
+			}()
+			waitFor(t, func() bool { return clock.Waiters() == 1 })
+			if tt.advance {
+				clock.Advance(time.Minute)
+			}
+			if tt.cancel {
+				cancel()
+			}
+
+			if err := <-done; !errors.Is(err, tt.want) {
+				t.Errorf("sleepContext() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendReportParamNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		names model.ParamNames
+		want  map[string]string
+	}{
+		{name: "defaults", want: map[string]string{"status": "up", "msg": "OK", "ping": "10.50", "loss": "25.00", "jitter": "1.00"}},
+		{
+			name:  "renamed",
+			names: model.ParamNames{Status: "s", Msg: "m", Ping: "latency"},
+			want:  map[string]string{"s": "up", "m": "OK", "latency": "10.50", "loss": "25.00", "status": "", "ping": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := make(chan url.Values, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query <- r.URL.Query()
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			cfg.ParamNames = tt.names
+			hb := model.Heartbeat{Status: statusUp, Msg: "OK", Result: model.PingResult{AvgRttMs: 10.5, PacketLoss: 25, Jitter: 1}}
+			if err := sendReport(context.Background(), cfg, srv.Client(), hb); err != nil {
+				t.Fatal(err)
+			}
+
+			got := <-query
+			for key, want := range tt.want {
+				if got.Get(key) != want {
+					t.Errorf("%s = %q, want %q", key, got.Get(key), want)
+				}
+			}
+		})
+	}
+}
+
+func TestPingWithGoPingStopsOnCancel(t *testing.T) {
+	cfg := testConfig("https://kuma.example.com")
+	cfg.PingProvider = nil
+	cfg.PingCount = 1000
+	cfg.PingDeadline = time.Minute
+	cfg.PrivilegedPing = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := pingWithGoPing(ctx, cfg, "127.0.0.1")
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		t.Skipf("no ICMP socket in this environment: %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("pingWithGoPing() = %v, want the context error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("pingWithGoPing() returned after %s, want right after the cancellation", elapsed)
+	}
+}
+
+func TestDryRunSendsNothing(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		transport string
+	}{
+		{name: "get", method: http.MethodGet},
+		{name: "post", method: http.MethodPost},
+		{name: "websocket", transport: transportWebSocket},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				requests.Add(1)
+			}))
+			defer srv.Close()
+
+			logs := &logRecorder{}
+			cfg := testConfig(srv.URL)
+			if tt.transport == transportWebSocket {
+				cfg.ReportURL = "ws" + strings.TrimPrefix(cfg.ReportURL, "http")
+			}
+			cfg.Logger = logs.log
+			cfg.DryRun = true
+			cfg.ReportMethod = tt.method
+			cfg.ReportTransport = tt.transport
+			sinks, err := reportSinks(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := reportWithRetry(context.Background(), cfg, &targetRuntime{sinks: sinks}); err != nil {
+				t.Fatal(err)
+			}
+			if got := requests.Load(); got != 0 {
+				t.Errorf("dry run sent %d requests", got)
+			}
+			if !logs.contains("INFO", "/api/push/****") {
+				t.Errorf("dry run did not log the redacted report: %v", logs.logged())
+			}
+		})
+	}
+}
+
+func TestSendReportAcceptableStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		accepted []int
+		wantErr  bool
+	}{
+		{name: "default ok", status: http.StatusOK},
+		{name: "default rejects no content", status: http.StatusNoContent, wantErr: true},
+		{name: "accepted no content", status: http.StatusNoContent, accepted: []int{200, 204}},
+		{name: "not in the list", status: http.StatusOK, accepted: []int{202}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			cfg.AcceptableReportStatus = tt.accepted
+			err := sendReport(context.Background(), cfg, srv.Client(), model.Heartbeat{Status: statusUp, Msg: "OK"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("sendReport() = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendWithRetryOnlyRetriesTransientErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		method       string
+		wantAttempts int64
+	}{
+		{name: "server error", status: http.StatusBadGateway, wantAttempts: 3},
+		{name: "rate limited", status: http.StatusTooManyRequests, wantAttempts: 3},
+		{name: "not found", status: http.StatusNotFound, wantAttempts: 1},
+		{name: "bad request", status: http.StatusBadRequest, wantAttempts: 1},
+		{name: "post without idempotency key", status: http.StatusBadGateway, method: http.MethodPost, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			cfg.Clock = NewFakeClock(testEpoch)
+			cfg.MaxRetries = 3
+			cfg.ReportMethod = tt.method
+			sinks, err := reportSinks(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = sendWithRetry(context.Background(), cfg, sinks[0], model.Heartbeat{Status: statusUp, Msg: "OK"}, time.Time{})
+			if err == nil {
+				t.Fatal("sendWithRetry() = nil, want the status error")
+			}
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("sent %d times, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestPingWithRetryStopsOnTerminalError(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantAttempts int
+	}{
+		{name: "retryable", err: retryable(errors.New("timeout")), wantAttempts: 3},
+		{name: "untagged", err: errors.New("timeout"), wantAttempts: 3},
+		{name: "terminal", err: terminal(errors.New("no such host")), wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.Clock = NewFakeClock(testEpoch)
+			cfg.MaxRetries = 3
+			cfg.PingProvider = failingProvider{tt.err}
+
+			_, attempts, err := pingWithRetry(context.Background(), cfg, time.Time{})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("pingWithRetry() = %v, want %v", err, tt.err)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+// failingProvider is a PingProvider that always fails with err.
+type failingProvider struct {
+	err error
+}
+
+func (p failingProvider) Ping(context.Context, string) (model.PingResult, error) {
+	return model.PingResult{}, p.err
+}
+
+func TestSendReportHealthchecks(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		status   string
+		wantPath string
+		wantBody string
+	}{
+		{name: "up", status: statusUp, wantPath: "/ping/uuid"},
+		{name: "down", status: statusDown, wantPath: "/ping/uuid/fail"},
+		{name: "post logs the result", method: http.MethodPost, status: statusDown, wantPath: "/ping/uuid/fail",
+			wantBody: "down: timeout (rtt=0.00ms loss=100%)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			type request struct {
+				method, path, body, contentType string
+			}
+			got := make(chan request, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				got <- request{r.Method, r.URL.Path, string(body), r.Header.Get("Content-Type")}
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			cfg.ReportURL = srv.URL + "/ping/uuid"
+			cfg.ReportProtocol = protocolHealthchecks
+			cfg.ReportMethod = tt.method
+			hb := model.Heartbeat{Status: tt.status, Msg: "timeout", Result: model.PingResult{PacketLoss: 100}}
+			if err := sendReport(context.Background(), cfg, srv.Client(), hb); err != nil {
+				t.Fatal(err)
+			}
+
+			req := <-got
+			if req.path != tt.wantPath || req.body != tt.wantBody {
+				t.Errorf("got %s %s %q, want %s %q", req.method, req.path, req.body, tt.wantPath, tt.wantBody)
+			}
+			if tt.method == http.MethodPost && req.contentType != "text/plain; charset=utf-8" {
+				t.Errorf("Content-Type = %q, want text/plain", req.contentType)
+			}
+		})
+	}
+}
+
+func TestNewPinger(t *testing.T) {
+	tests := []struct {
+		name       string
+		ip         string
+		privileged bool
+		size       int
+		deadline   time.Duration
+		wantErr    bool
+	}{
+		{name: "unprivileged v4", ip: "192.0.2.1", size: 0},
+		{name: "privileged v4", ip: "192.0.2.1", privileged: true, size: 1400},
+		{name: "unprivileged v6", ip: "2001:db8::1"},
+		{name: "no deadline", ip: "192.0.2.1", deadline: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.PrivilegedPing = tt.privileged
+			cfg.PingPacketSize = tt.size
+			if tt.deadline != 0 {
+				cfg.PingDeadline = tt.deadline
+			}
+
+			pinger, err := newPinger(cfg, tt.ip)
+			if tt.wantErr {
+				if err == nil || isRetryable(err) {
+					t.Fatalf("newPinger() = %v, want a terminal error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pinger.Privileged() != tt.privileged {
+				t.Errorf("Privileged() = %t, want %t", pinger.Privileged(), tt.privileged)
+			}
+			if got := pinger.IPAddr().IP.String(); got != tt.ip {
+				t.Errorf("IPAddr() = %s, want %s", got, tt.ip)
+			}
+			if tt.size > 0 && pinger.Size != tt.size {
+				t.Errorf("Size = %d, want %d", pinger.Size, tt.size)
+			}
+			if pinger.Count != cfg.PingCount || pinger.Timeout != cfg.PingDeadline {
+				t.Errorf("Count, Timeout = %d, %s, want %d, %s", pinger.Count, pinger.Timeout, cfg.PingCount, cfg.PingDeadline)
+			}
+		})
+	}
+}
+
+func TestSendReportMetadata(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip("no hostname: ", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		include    bool
+		source     string
+		wantSource string
+	}{
+		{name: "get off", method: http.MethodGet},
+		{name: "get hostname", method: http.MethodGet, include: true, wantSource: hostname},
+		{name: "get source name", method: http.MethodGet, include: true, source: "edge-1", wantSource: "edge-1"},
+		{name: "post off", method: http.MethodPost, source: "edge-1"},
+		{name: "post hostname", method: http.MethodPost, include: true, wantSource: hostname},
+		{name: "post source name", method: http.MethodPost, include: true, source: "edge-1", wantSource: "edge-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := make(chan map[string]string, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got := map[string]string{"source": r.URL.Query().Get("source"), "version": r.URL.Query().Get("version")}
+				if r.Method == http.MethodPost {
+					var payload reportPayload
+					_ = json.NewDecoder(r.Body).Decode(&payload)
+					got = map[string]string{"source": payload.Source, "version": payload.Version}
+				}
+				fields <- got
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			cfg.ReportMethod = tt.method
+			cfg.IncludeMetadata = tt.include
+			cfg.SourceName = tt.source
+			hb := model.Heartbeat{Status: statusUp, Msg: "OK", Result: model.PingResult{AvgRttMs: 10}}
+			if err := sendReport(context.Background(), cfg, srv.Client(), hb); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]string{"source": "", "version": ""}
+			if tt.include {
+				want = map[string]string{"source": tt.wantSource, "version": version.Version}
+			}
+			got := <-fields
+			for key := range want {
+				if got[key] != want[key] {
+					t.Errorf("%s = %q, want %q", key, got[key], want[key])
+				}
+			}
+		})
+	}
+}
+
+func TestSendReportUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		userAgent string
+		want      string
+	}{
+		{name: "get default", method: http.MethodGet, want: "kuma-reporter/" + version.Version},
+		{name: "post default", method: http.MethodPost, want: "kuma-reporter/" + version.Version},
+		{name: "get override", method: http.MethodGet, userAgent: "probe/2", want: "probe/2"},
+		{name: "post override", method: http.MethodPost, userAgent: "probe/2", want: "probe/2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userAgent := make(chan string, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userAgent <- r.UserAgent()
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			cfg.ReportMethod = tt.method
+			cfg.UserAgent = tt.userAgent
+			hb := model.Heartbeat{Status: statusUp, Msg: "OK", Result: model.PingResult{AvgRttMs: 10}}
+			if err := sendReport(context.Background(), cfg, srv.Client(), hb); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := <-userAgent; got != tt.want {
+				t.Errorf("User-Agent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// lossProvider answers with the next packet loss (in percent) of losses.
+type lossProvider struct {
+	losses []float64
+	next   atomic.Int64
+}
+
+func (p *lossProvider) Ping(context.Context, string) (model.PingResult, error) {
+	loss := p.losses[int(p.next.Add(1)-1)%len(p.losses)]
+	return model.PingResult{AvgRttMs: 10, PacketLoss: loss, Sent: 4, Recv: 4 - int(loss/25)}, nil
+}
+
+func TestFlapDebounce(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		losses    []float64
+		// want is the status reported by each cycle, "" when held off
+		want []string
+	}{
+		{name: "off", losses: []float64{75, 0}, want: []string{"down", "up"}},
+		{name: "held off", threshold: 3, losses: []float64{75, 75, 75, 75, 0}, want: []string{"", "", "down", "down", "up"}},
+		{name: "good cycle resets", threshold: 3, losses: []float64{75, 75, 0, 75, 75}, want: []string{"", "", "up", "", ""}},
+		{name: "loss below the threshold", threshold: 2, losses: []float64{25, 25}, want: []string{"up", "up"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statuses := make(chan string, len(tt.want))
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				statuses <- r.URL.Query().Get("status")
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			cfg.FlapThreshold = tt.threshold
+			cfg.LossDownThreshold = 50
+			cfg.PingProvider = &lossProvider{losses: tt.losses}
+			sinks, err := reportSinks(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rt := &targetRuntime{sinks: sinks}
+			for cycle, want := range tt.want {
+				_, _ = reportWithRetry(context.Background(), cfg, rt)
+
+				got := ""
+				select {
+				case got = <-statuses:
+				default:
+				}
+				if got != want {
+					t.Errorf("cycle %d reported %q, want %q", cycle+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPingDualStack(t *testing.T) {
+	v4, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v4.Close()
+	_, port, _ := net.SplitHostPort(v4.Addr().String())
+	v6, err := net.Listen("tcp6", net.JoinHostPort("::1", port))
+	if err != nil {
+		t.Skip("no IPv6 loopback: ", err)
+	}
+	defer v6.Close()
+
+	tests := []struct {
+		name    string
+		ips     string
+		wantIP  string
+		wantV4  bool
+		wantV6  bool
+		wantErr bool
+	}{
+		{name: "both", ips: "::1, 127.0.0.1", wantIP: "127.0.0.1", wantV4: true, wantV6: true},
+		{name: "first of each family", ips: "127.0.0.1, 127.0.0.2, ::1", wantIP: "127.0.0.1", wantV4: true, wantV6: true},
+		{name: "no IPv6 address", ips: "127.0.0.1", wantIP: "127.0.0.1", wantV4: true},
+		{name: "no IPv4 address", ips: "::1", wantIP: "::1", wantV6: true},
+		// Nothing listens on 127.0.0.2
+		{name: "IPv4 down", ips: "127.0.0.2, ::1", wantIP: "::1", wantV6: true},
+		{name: "both down", ips: "127.0.0.2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.CheckMode = checkModeTCP
+			cfg.DualStack = true
+			cfg.HostOverrides = map[string]string{"dual.example.com": tt.ips}
+
+			result, err := pingDualStack(context.Background(), cfg, "dual.example.com", port)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("pingDualStack() = %+v, want an error", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.IP != tt.wantIP {
+				t.Errorf("main result from %s, want %s", result.IP, tt.wantIP)
+			}
+			if (result.V4 != nil) != tt.wantV4 || (result.V6 != nil) != tt.wantV6 {
+				t.Errorf("V4 answered %t, V6 answered %t, want %t, %t", result.V4 != nil, result.V6 != nil, tt.wantV4, tt.wantV6)
+			}
+		})
+	}
+}
+
+func TestAddFamilyParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		result *model.PingResult
+		omit   bool
+		want   url.Values
+	}{
+		{name: "answered", result: &model.PingResult{AvgRttMs: 12.345}, want: url.Values{"status_v6": {"up"}, "ping_v6": {"12.35"}}},
+		{name: "answered omitted", result: &model.PingResult{AvgRttMs: 12.345}, omit: true, want: url.Values{"status_v6": {"up"}}},
+		{name: "no result", want: url.Values{"status_v6": {"down"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.OmitPingValue = tt.omit
+
+			params := url.Values{}
+			addFamilyParams(cfg, params, "v6", tt.result)
+			if params.Encode() != tt.want.Encode() {
+				t.Errorf("params %q, want %q", params.Encode(), tt.want.Encode())
+			}
+		})
+	}
+}
+
+func TestSendReportCancelMidRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+	}{
+		{name: "get", method: http.MethodGet},
+		{name: "post", method: http.MethodPost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arrived, done := make(chan struct{}), make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Drain the body, the server only watches for a closed connection after that
+				_, _ = io.Copy(io.Discard, r.Body)
+				close(arrived)
+				select {
+				case <-r.Context().Done():
+				case <-done:
+				}
+			}))
+			defer srv.Close()
+			defer close(done)
+
+			cfg := testConfig(srv.URL)
+			cfg.ReportMethod = tt.method
+			// The client timeout must not be what ends the request
+			cfg.HTTPTimeout = time.Minute
+			client, err := newReportClient(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-arrived
+				cancel()
+			}()
+			start := time.Now()
+			err = sendReport(ctx, cfg, client, model.Heartbeat{Status: statusUp, Msg: "OK"})
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("sendReport() = %v, want context.Canceled", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("cancel took %s", elapsed)
+			}
+		})
+	}
+}
+
+// hostProvider answers each host with its RTT in ms, a negative RTT or an
+// unknown host loses every packet.
+type hostProvider map[string]float64
+
+func (p hostProvider) Ping(_ context.Context, host string) (model.PingResult, error) {
+	rtt, ok := p[host]
+	if !ok || rtt < 0 {
+		return model.PingResult{}, fmt.Errorf("%w from %s", errNoResponse, host)
+	}
+	return model.PingResult{IP: host, AvgRttMs: rtt, MinRttMs: rtt - 1, MaxRttMs: rtt + 1, Sent: 1, Recv: 1}, nil
+}
+
+func TestSplitHosts(t *testing.T) {
+	tests := []struct {
+		pingHost string
+		want     []string
+	}{
+		{pingHost: "a.example.com", want: []string{"a.example.com"}},
+		{pingHost: "a.example.com,b.example.com", want: []string{"a.example.com", "b.example.com"}},
+		{pingHost: " a.example.com , ,b.example.com, ", want: []string{"a.example.com", "b.example.com"}},
+		{pingHost: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pingHost, func(t *testing.T) {
+			if got := splitHosts(tt.pingHost); !slices.Equal(got, tt.want) {
+				t.Errorf("splitHosts(%q) = %q, want %q", tt.pingHost, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPingHostsAggregate(t *testing.T) {
+	provider := hostProvider{"a": 10, "b": 30, "c": 20, "down": -1}
+
+	tests := []struct {
+		name      string
+		pingHost  string
+		aggregate string
+		wantRtt   float64
+		wantIP    string
+		wantLoss  float64
+		wantErr   bool
+	}{
+		{name: "min by default", pingHost: "a,b,c", wantRtt: 10, wantIP: "a"},
+		{name: "min", pingHost: "b, a, c", aggregate: "min", wantRtt: 10, wantIP: "a"},
+		{name: "max", pingHost: "a,b,c", aggregate: "max", wantRtt: 30, wantIP: "b"},
+		{name: "avg", pingHost: "a,b,c", aggregate: "avg", wantRtt: 20, wantIP: "a"},
+		{name: "one host down", pingHost: "a,down,b,c", aggregate: "max", wantRtt: 30, wantIP: "b", wantLoss: 25},
+		{name: "avg skips the down host", pingHost: "a,down,b", aggregate: "avg", wantRtt: 20, wantIP: "a", wantLoss: 100.0 / 3},
+		{name: "all down", pingHost: "down,gone", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.PingHost = tt.pingHost
+			cfg.PingHostAggregate = tt.aggregate
+			cfg.PingProvider = provider
+
+			result, _, err := measure(context.Background(), cfg, nil)
+			if tt.wantErr {
+				if !errors.Is(err, errNoResponse) {
+					t.Fatalf("measure() = %v, want no response", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(result.AvgRttMs-tt.wantRtt) > 1e-9 || result.IP != tt.wantIP {
+				t.Errorf("measure() = %.2f ms from %s, want %.2f ms from %s", result.AvgRttMs, result.IP, tt.wantRtt, tt.wantIP)
+			}
+			if math.Abs(result.PacketLoss-tt.wantLoss) > 1e-9 {
+				t.Errorf("packet loss %.2f%%, want %.2f%%", result.PacketLoss, tt.wantLoss)
+			}
+		})
+	}
+}
+
+func TestPingWithGoPingLoopback(t *testing.T) {
+	tests := []struct {
+		name        string
+		ip          string
+		wantNetwork string
+	}{
+		{name: "IPv4", ip: "127.0.0.1", wantNetwork: "ip4"},
+		{name: "IPv6", ip: "::1", wantNetwork: "ip6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.PingProvider = nil
+			cfg.PingCount = 2
+			cfg.PingInterval = 10 * time.Millisecond
+			cfg.PrivilegedPing = true
+
+			pinger, err := newPinger(cfg, tt.ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// go-ping keeps the network unexported
+			if network := reflect.ValueOf(pinger).Elem().FieldByName("network").String(); network != tt.wantNetwork {
+				t.Errorf("pinger network %q, want %q", network, tt.wantNetwork)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			result, err := pingWithGoPing(ctx, cfg, tt.ip)
+			if errors.Is(err, context.DeadlineExceeded) {
+				t.Fatal("ping hung until the context deadline")
+			}
+			if err != nil {
+				t.Skipf("no %s ICMP socket in this environment: %v", tt.name, err)
+			}
+			if result.Sent != cfg.PingCount || result.Recv != cfg.PingCount {
+				t.Errorf("sent %d, received %d, want %d of each", result.Sent, result.Recv, cfg.PingCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package method
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxBackups  int
+		writes      int
+		wantBackups int
+	}{
+		{name: "no rotation needed", maxBackups: 2, writes: 2, wantBackups: 0},
+		{name: "rotates", maxBackups: 2, writes: 4, wantBackups: 1},
+		{name: "keeps max backups", maxBackups: 2, writes: 12, wantBackups: 2},
+		{name: "truncates without backups", maxBackups: 0, writes: 12, wantBackups: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "reporter.log")
+			f, err := openRotatingFile(path, 1, tt.maxBackups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = f.file.Close()
+			}()
+			// Three 40 byte lines per file
+			f.maxSize = 120
+
+			for i := range tt.writes {
+				if _, err := fmt.Fprintf(f, "%-39d\n", i); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i := 1; i <= tt.maxBackups+1; i++ {
+				_, err := os.Stat(fmt.Sprintf("%s.%d", path, i))
+				if exists := err == nil; exists != (i <= tt.wantBackups) {
+					t.Errorf("backup %d exists = %t, want %t", i, exists, i <= tt.wantBackups)
+				}
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := fmt.Sprintf("%-39d\n", tt.writes-1); !strings.HasSuffix(string(data), last) {
+				t.Errorf("current file %q does not end with the last write", data)
+			}
+		})
+	}
+}
+
+func TestNewFileLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reporter.log")
+	logger, err := NewFileLogger(path, 10, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger("INFO", "Report successful! Ping: ", 12.5)
+	prefixLogger("db", logger)("WARN", "Degraded")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{" [INFO] Report successful! Ping: 12.5", " [WARN] [db] Degraded"}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %q", len(lines), len(want), lines)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %d = %q, want it to end with %q", i, line, want[i])
+		}
+	}
+}
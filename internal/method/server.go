@@ -0,0 +1,60 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// startServers starts the metrics and health listeners that are configured,
+// sharing one listener when both use the same address. They stop when ctx
+// is cancelled.
+func startServers(ctx context.Context, cfg model.Config, wg *sync.WaitGroup) {
+	muxes := map[string]*http.ServeMux{}
+	mux := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+
+	if cfg.MetricsListenAddr != "" {
+		mux(cfg.MetricsListenAddr).Handle("/metrics", metricsHandler())
+	}
+	if cfg.HealthListenAddr != "" {
+		m := mux(cfg.HealthListenAddr)
+		m.HandleFunc("/healthz", healthzHandler)
+		m.HandleFunc("/readyz", readyzHandler)
+	}
+
+	for addr, handler := range muxes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveHTTP(ctx, addr, handler)
+		}()
+	}
+}
+
+// serveHTTP serves handler on addr until ctx is cancelled.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	Logger("INFO", "HTTP listening on ", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		Logger("ERROR", "HTTP server on ", addr, " failed: ", err)
+	}
+}
@@ -0,0 +1,105 @@
+package method
+
+import (
+	"encoding/json"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+type targetState struct {
+	Time   string  `json:"time"`
+	Status string  `json:"status"`
+	RttMs  float64 `json:"rtt_ms"`
+	Loss   float64 `json:"loss_percent"`
+	IP     string  `json:"ip,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+var (
+	stateMu sync.Mutex
+	states  = map[string]targetState{}
+)
+
+// writeState records the outcome of a cycle in StateFilePath, a JSON object
+// keyed by target name, or by PingHost when name is empty. The file is
+// replaced atomically so readers never see a partial write.
+func writeState(cfg model.Config, name, status string, result model.PingResult, cycleErr error) {
+	if cfg.StateFilePath == "" {
+		return
+	}
+	if name == "" {
+		name = cfg.PingHost
+	}
+
+	state := targetState{
+		Time:   clockOf(cfg).Now().Format(time.RFC3339),
+		Status: status,
+		RttMs:  result.AvgRttMs,
+		Loss:   result.PacketLoss,
+		IP:     result.IP,
+	}
+	if cycleErr != nil {
+		state.Error = cycleErr.Error()
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	states[name] = state
+	saveStates(cfg.StateFilePath, cfg.Logger)
+}
+
+// retainState drops the state of the targets a reload removed and rewrites
+// the file when it did.
+func retainState(cfg model.Config, names []string) {
+	if cfg.StateFilePath == "" {
+		return
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	before := len(states)
+	maps.DeleteFunc(states, func(name string, _ targetState) bool {
+		return !slices.Contains(names, name)
+	})
+	if len(states) < before {
+		saveStates(cfg.StateFilePath, Logger)
+	}
+}
+
+// saveStates writes states to path, the caller holds stateMu.
+func saveStates(path string, logger func(string, ...any)) {
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		logger("ERROR", "Cannot encode state: ", err)
+		return
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		logger("ERROR", "Cannot write state file: ", err)
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".state-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
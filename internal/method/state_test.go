@@ -0,0 +1,73 @@
+package method
+
+import (
+	"encoding/json"
+	"errors"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteState(t *testing.T) {
+	stateMu.Lock()
+	clear(states)
+	stateMu.Unlock()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	cfg := testConfig("https://kuma.example.com")
+	cfg.Clock = NewFakeClock(testEpoch)
+	cfg.StateFilePath = path
+
+	cycles := []struct {
+		name   string
+		host   string
+		status string
+		result model.PingResult
+		err    error
+	}{
+		{host: "a.example.com", status: statusUp, result: model.PingResult{AvgRttMs: 10, IP: "192.0.2.1"}},
+		{name: "b-primary", host: "b.example.com", status: statusDown, result: model.PingResult{PacketLoss: 100}, err: errors.New("no response")},
+		// Same host as the one before, kept apart by its name
+		{name: "b-backup", host: "b.example.com", status: statusUp, result: model.PingResult{AvgRttMs: 20}},
+	}
+	for _, cycle := range cycles {
+		cfg.PingHost = cycle.host
+		writeState(cfg, cycle.name, cycle.status, cycle.result, cycle.err)
+	}
+
+	checkStateFile(t, path, map[string]targetState{
+		"a.example.com": {Time: "2024-01-01T00:00:00Z", Status: statusUp, RttMs: 10, IP: "192.0.2.1"},
+		"b-primary":     {Time: "2024-01-01T00:00:00Z", Status: statusDown, Loss: 100, Error: "no response"},
+		"b-backup":      {Time: "2024-01-01T00:00:00Z", Status: statusUp, RttMs: 20},
+	})
+
+	// A reload that removed a target drops its entry right away
+	retainState(cfg, []string{"a.example.com", "b-backup"})
+	checkStateFile(t, path, map[string]targetState{
+		"a.example.com": {Time: "2024-01-01T00:00:00Z", Status: statusUp, RttMs: 10, IP: "192.0.2.1"},
+		"b-backup":      {Time: "2024-01-01T00:00:00Z", Status: statusUp, RttMs: 20},
+	})
+}
+
+func checkStateFile(t *testing.T, path string, want map[string]targetState) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]targetState
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, state := range want {
+		if got[name] != state {
+			t.Errorf("state of %s = %+v, want %+v", name, got[name], state)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("state file has %d targets, want %d", len(got), len(want))
+	}
+}
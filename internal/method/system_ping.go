@@ -0,0 +1,309 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"io/fs"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func pingWithSystem(ctx context.Context, cfg model.Config, ip string) (model.PingResult, error) {
+	count, deadline := cfg.PingCount, pingDeadline(cfg)
+	cmdName := systemPingCommand(runtime.GOOS, cfg, ip)
+
+	args := systemPingArgs(runtime.GOOS, cfg, ip)
+
+	// Cancelling the parent kills the subprocess right away
+	ctx, cancel := context.WithTimeout(ctx, deadline+2*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, cmdName, args...)
+	// Ask for the untranslated summary, the locale fallbacks below cover
+	// systems that ignore it
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "LANG=C")
+	output, err := cmd.CombinedOutput()
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(ctxErr, context.DeadlineExceeded) {
+		return model.PingResult{}, ctxErr
+	}
+	// A name missing from PATH or a configured path that does not exist
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		err = terminal(fmt.Errorf("system ping binary %q not found, install iputils or disable use_system_ping: %w", cmdName, err))
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, err
+	}
+	if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) {
+		// ping exits non-zero when nothing came back, which is a down target
+		// rather than a broken command
+		if loss, ok := parseSystemPingLoss(strings.Split(string(output), "\n")); ok && loss >= 100 {
+			err = fmt.Errorf("%w from %s", errNoResponse, ip)
+			cfg.Logger("ERROR", err)
+			return model.PingResult{}, err
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("system ping command failed: %w, output: %s", err, string(output))
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, err
+	}
+
+	result, err := parseSystemPingOutput(string(output), count)
+	if err != nil {
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, err
+	}
+
+	return result, nil
+}
+
+// systemPingCommand picks the ping binary on goos, SystemPing6Path for v6
+// addresses when set, then SystemPingPath, then "ping" from PATH ("ping6" for
+// v6 on macOS, whose ping only speaks IPv4).
+func systemPingCommand(goos string, cfg model.Config, ip string) string {
+	v6 := isIPv6(ip)
+	if v6 && cfg.SystemPing6Path != "" {
+		return cfg.SystemPing6Path
+	}
+	if cfg.SystemPingPath != "" {
+		return cfg.SystemPingPath
+	}
+	if v6 && goos == "darwin" {
+		return "ping6"
+	}
+
+	return "ping"
+}
+
+func isIPv6(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() == nil
+}
+
+// systemPingArgs returns the system ping arguments for cfg on goos, the
+// buildPingArgs ones plus the optional interval and packet size.
+func systemPingArgs(goos string, cfg model.Config, ip string) []string {
+	args := buildPingArgs(goos, ip, cfg.PingCount, cfg.PingPacketTimeout, pingDeadline(cfg))
+	if cfg.PingInterval > 0 && goos != "windows" {
+		// Windows ping has no interval option
+		interval := strconv.FormatFloat(cfg.PingInterval.Seconds(), 'f', -1, 64)
+		args = append([]string{"-i", interval}, args...)
+	}
+	if cfg.PingPacketSize > 0 {
+		sizeFlag := "-s"
+		if goos == "windows" {
+			sizeFlag = "-l"
+		}
+		args = append([]string{sizeFlag, strconv.Itoa(cfg.PingPacketSize)}, args...)
+	}
+
+	return args
+}
+
+// buildPingArgs returns the system ping arguments sending count packets to ip
+// on goos, waiting up to packetTimeout per reply and deadline overall. A zero
+// packetTimeout leaves the per reply wait to the platform default, except on
+// Windows where the deadline is split across the packets and also caps a
+// longer packetTimeout. Unknown systems get the Linux flags.
+func buildPingArgs(goos string, ip string, count int, packetTimeout, deadline time.Duration) []string {
+	v6 := isIPv6(ip)
+	deadlineSeconds := strconv.Itoa(max(int(math.Ceil(deadline.Seconds())), 1))
+
+	var args []string
+	switch goos {
+	case "darwin": // macOS
+		args = []string{"-c", strconv.Itoa(count)}
+		// ping6 has no deadline flag, the context deadline bounds it
+		if !v6 {
+			args = append(args, "-t", deadlineSeconds)
+		}
+		if packetTimeout > 0 {
+			args = append(args, "-W", strconv.FormatInt(packetTimeout.Milliseconds(), 10))
+		}
+	case "windows":
+		// -w is the wait per reply, split the deadline so all replies fit in
+		// it, a shorter packetTimeout wins
+		perReply := deadline.Milliseconds() / int64(count)
+		if packetTimeout > 0 {
+			perReply = min(packetTimeout.Milliseconds(), perReply)
+		}
+		perReply = max(perReply, 1)
+		args = []string{"-n", strconv.Itoa(count), "-w", strconv.FormatInt(perReply, 10)}
+		if v6 {
+			args = append([]string{"-6"}, args...)
+		}
+	default: // Linux and other unix-like system
+		args = []string{"-c", strconv.Itoa(count), "-w", deadlineSeconds}
+		if packetTimeout > 0 {
+			args = append(args, "-W", strconv.Itoa(max(int(math.Ceil(packetTimeout.Seconds())), 1)))
+		}
+		if v6 {
+			args = append([]string{"-6"}, args...)
+		}
+	}
+
+	return append(args, ip)
+}
+
+// pingDeadline is the time budget of a whole measurement, PingDeadline or
+// the deprecated PingTimeout.
+func pingDeadline(cfg model.Config) time.Duration {
+	if cfg.PingDeadline > 0 {
+		return cfg.PingDeadline
+	}
+
+	return cfg.PingTimeout
+}
+
+// packetTimeout is the wait for a single probe, the deadline when unset.
+func packetTimeout(cfg model.Config) time.Duration {
+	if cfg.PingPacketTimeout > 0 {
+		return cfg.PingPacketTimeout
+	}
+
+	return pingDeadline(cfg)
+}
+
+var (
+	// "<any label> = 1.234/2.345/3.456/0.123 ms", decimals may use a comma
+	rttSummaryRe = regexp.MustCompile(`=\s*([\d.,]+)/([\d.,]+)/([\d.,]+)(?:/([\d.,]+))?\s*ms`)
+	// Windows "Minimum = 1ms, Maximum = 2ms"
+	windowsMinMaxRe = regexp.MustCompile(`Minimum = (\d+)ms, Maximum = (\d+)ms`)
+	// Windows "Average = 3ms" and its translations
+	windowsAverageRe = regexp.MustCompile(`(?i)(?:Average|Mittelwert|Moyenne|Media|Média|Gemiddelde|Średnia)\s*=\s*(\d+)\s*ms`)
+	// "0% packet loss", "0 % paquets perdus", "(0% Verlust)", "(perte 0%)"
+	lossRe = regexp.MustCompile(`(\d+(?:[.,]\d+)?)\s*%`)
+	// Per packet "time=5.12 ms", "time<1ms" or "Zeit=5ms"
+	packetTimeRe = regexp.MustCompile(`(?i)(?:time|zeit|temps|tiempo|tempo)[=<]\s*([\d.,]+)\s*ms`)
+	lossKeywords = []string{"loss", "verlust", "perte", "perdu", "pérdida", "perdidos", "perdita", "verloren", "straty"}
+)
+
+// parseSystemPingOutput reads the summary of a system ping run that sent
+// packets, falling back to the individual replies when there is none.
+func parseSystemPingOutput(output string, sent int) (model.PingResult, error) {
+	lines := strings.Split(output, "\n")
+	loss, lossFound := parseSystemPingLoss(lines)
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := lines[i]
+
+		// "round-trip min/avg/max/stddev = 1.234/2.345/3.456/0.123 ms"
+		if strings.Contains(line, "round-trip") || strings.Contains(line, "rtt") {
+			parts := strings.Fields(line)
+			for _, part := range parts {
+				if strings.Contains(part, "/") {
+					stats := strings.Split(part, "/")
+					if len(stats) >= 4 {
+						avg, err := strconv.ParseFloat(stats[1], 64)
+						if err == nil {
+							minRtt, _ := strconv.ParseFloat(stats[0], 64)
+							maxRtt, _ := strconv.ParseFloat(stats[2], 64)
+							jitter, _ := strconv.ParseFloat(stats[3], 64)
+							result := model.PingResult{AvgRttMs: avg, MinRttMs: minRtt, MaxRttMs: maxRtt, PacketLoss: loss, Jitter: jitter}
+							return withPacketCounts(result, sent), nil
+						}
+					}
+				}
+			}
+		}
+
+		// "Minimum = 1ms, Maximum = 2ms, Average = 3ms"
+		if strings.Contains(line, "Average =") {
+			parts := strings.Fields(line)
+			for i, part := range parts {
+				if part == "Average" && i+2 < len(parts) {
+					avgStr := strings.TrimSuffix(parts[i+2], "ms")
+					avg, err := strconv.ParseFloat(avgStr, 64)
+					if err == nil {
+						result := model.PingResult{AvgRttMs: avg, PacketLoss: loss}
+						if m := windowsMinMaxRe.FindStringSubmatch(line); m != nil {
+							result.MinRttMs, _ = strconv.ParseFloat(m[1], 64)
+							result.MaxRttMs, _ = strconv.ParseFloat(m[2], 64)
+						}
+						return withPacketCounts(result, sent), nil
+					}
+				}
+			}
+		}
+	}
+
+	// Localized summaries
+	for i := len(lines) - 1; i >= 0; i-- {
+		if m := rttSummaryRe.FindStringSubmatch(lines[i]); m != nil {
+			avg, err := parseLocalizedFloat(m[2])
+			if err == nil {
+				minRtt, _ := parseLocalizedFloat(m[1])
+				maxRtt, _ := parseLocalizedFloat(m[3])
+				jitter, _ := parseLocalizedFloat(m[4])
+				result := model.PingResult{AvgRttMs: avg, MinRttMs: minRtt, MaxRttMs: maxRtt, PacketLoss: loss, Jitter: jitter}
+				return withPacketCounts(result, sent), nil
+			}
+		}
+		if m := windowsAverageRe.FindStringSubmatch(lines[i]); m != nil {
+			avg, err := parseLocalizedFloat(m[1])
+			if err == nil {
+				return withPacketCounts(model.PingResult{AvgRttMs: avg, PacketLoss: loss}, sent), nil
+			}
+		}
+	}
+
+	// No summary, e.g. BusyBox after losing packets: average the replies
+	var rtts []float64
+	for _, line := range lines {
+		if m := packetTimeRe.FindStringSubmatch(line); m != nil {
+			rtt, err := parseLocalizedFloat(m[1])
+			if err == nil {
+				rtts = append(rtts, rtt)
+			}
+		}
+	}
+	if len(rtts) > 0 {
+		result := summarizeRtts(rtts, max(sent, len(rtts)))
+		if lossFound {
+			result.PacketLoss = loss
+		}
+		return result, nil
+	}
+
+	return model.PingResult{}, fmt.Errorf("could not parse ping output: %s", output)
+}
+
+// withPacketCounts derives the received count from the loss percentage, the
+// summaries only report the latter reliably across platforms.
+func withPacketCounts(result model.PingResult, sent int) model.PingResult {
+	result.Sent = sent
+	result.Recv = int(math.Round(float64(sent) * (100 - result.PacketLoss) / 100))
+	return result
+}
+
+// parseSystemPingLoss finds the loss percentage, defaulting to zero.
+func parseSystemPingLoss(lines []string) (float64, bool) {
+	for _, line := range lines {
+		lower := strings.ToLower(line)
+		for _, keyword := range lossKeywords {
+			if !strings.Contains(lower, keyword) {
+				continue
+			}
+			if m := lossRe.FindStringSubmatch(line); m != nil {
+				loss, err := parseLocalizedFloat(m[1])
+				if err == nil {
+					return loss, true
+				}
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func parseLocalizedFloat(value string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+}
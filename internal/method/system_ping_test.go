@@ -0,0 +1,344 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsIPv6(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "192.0.2.1", want: false},
+		{ip: "::ffff:192.0.2.1", want: false},
+		{ip: "2001:db8::1", want: true},
+		{ip: "::1", want: true},
+		{ip: "example.com", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := isIPv6(tt.ip); got != tt.want {
+				t.Errorf("isIPv6(%q) = %t, want %t", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSystemPingOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		sent   int
+		want   model.PingResult
+	}{
+		{
+			name: "linux",
+			output: `PING example.com (192.0.2.1) 56(84) bytes of data.
+64 bytes from 192.0.2.1: icmp_seq=1 ttl=56 time=10.1 ms
+
+--- example.com ping statistics ---
+4 packets transmitted, 3 received, 25% packet loss, time 3004ms
+rtt min/avg/max/mdev = 9.800/10.200/10.900/0.400 ms`,
+			sent: 4,
+			want: model.PingResult{AvgRttMs: 10.2, MinRttMs: 9.8, MaxRttMs: 10.9, Jitter: 0.4, PacketLoss: 25, Sent: 4, Recv: 3},
+		},
+		{
+			name: "macos",
+			output: `--- example.com ping statistics ---
+2 packets transmitted, 2 packets received, 0.0% packet loss
+round-trip min/avg/max/stddev = 1.234/2.345/3.456/0.123 ms`,
+			sent: 2,
+			want: model.PingResult{AvgRttMs: 2.345, MinRttMs: 1.234, MaxRttMs: 3.456, Jitter: 0.123, Sent: 2, Recv: 2},
+		},
+		{
+			name: "german linux",
+			output: `--- example.com Ping-Statistiken ---
+4 Pakete übertragen, 4 empfangen, 0% Paketverlust, Zeit 3004ms
+rtt Min/Mittel/Max/Abw = 9,800/10,200/10,900/0,400 ms`,
+			sent: 4,
+			want: model.PingResult{AvgRttMs: 10.2, MinRttMs: 9.8, MaxRttMs: 10.9, Jitter: 0.4, Sent: 4, Recv: 4},
+		},
+		{
+			name: "windows",
+			output: `Ping statistics for 192.0.2.1:
+    Packets: Sent = 4, Received = 4, Lost = 0 (0% loss),
+Approximate round trip times in milli-seconds:
+    Minimum = 9ms, Maximum = 12ms, Average = 10ms`,
+			sent: 4,
+			want: model.PingResult{AvgRttMs: 10, MinRttMs: 9, MaxRttMs: 12, Sent: 4, Recv: 4},
+		},
+		{
+			name: "french windows",
+			output: `Statistiques Ping pour 192.0.2.1:
+    Paquets : envoyés = 4, reçus = 2, perdus = 2 (perte 50%),
+Durée approximative des boucles en millisecondes :
+    Minimum = 9ms, Maximum = 12ms, Moyenne = 10ms`,
+			sent: 4,
+			want: model.PingResult{AvgRttMs: 10, PacketLoss: 50, Sent: 4, Recv: 2},
+		},
+		{
+			name: "busybox without summary",
+			output: `64 bytes from 192.0.2.1: seq=0 ttl=56 time=10.000 ms
+64 bytes from 192.0.2.1: seq=2 ttl=56 time=20.000 ms
+4 packets transmitted, 2 packets received, 50% packet loss`,
+			sent: 4,
+			want: model.PingResult{AvgRttMs: 15, MinRttMs: 10, MaxRttMs: 20, Jitter: 5, PacketLoss: 50, Sent: 4, Recv: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSystemPingOutput(tt.output, tt.sent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseSystemPingOutput() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSystemPingOutputUnknown(t *testing.T) {
+	if _, err := parseSystemPingOutput("ping: unknown host example.invalid", 4); err == nil {
+		t.Error("parseSystemPingOutput() = nil error for output without results")
+	}
+}
+
+func TestSystemPingArgsPacketSize(t *testing.T) {
+	tests := []struct {
+		goos string
+		size int
+		want []string
+	}{
+		{goos: "linux", size: 0, want: []string{"-c", "4", "-w", "10", "192.0.2.1"}},
+		{goos: "linux", size: 1400, want: []string{"-s", "1400", "-c", "4", "-w", "10", "192.0.2.1"}},
+		{goos: "darwin", size: 1400, want: []string{"-s", "1400", "-c", "4", "-t", "10", "192.0.2.1"}},
+		{goos: "windows", size: 1400, want: []string{"-l", "1400", "-n", "4", "-w", "2500", "192.0.2.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.goos, tt.size), func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.PingCount = 4
+			cfg.PingDeadline = 10 * time.Second
+			cfg.PingPacketSize = tt.size
+
+			if got := systemPingArgs(tt.goos, cfg, "192.0.2.1"); !slices.Equal(got, tt.want) {
+				t.Errorf("systemPingArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildPingArgsWindowsWaitPerReply(t *testing.T) {
+	tests := []struct {
+		name          string
+		count         int
+		packetTimeout time.Duration
+		deadline      time.Duration
+		wantWait      string
+	}{
+		{name: "deadline split across replies", count: 4, deadline: 10 * time.Second, wantWait: "2500"},
+		{name: "single packet", count: 1, deadline: 10 * time.Second, wantWait: "10000"},
+		{name: "explicit packet timeout", count: 4, packetTimeout: time.Second, deadline: 10 * time.Second, wantWait: "1000"},
+		{name: "packet timeout past the deadline", count: 4, packetTimeout: 5 * time.Second, deadline: 10 * time.Second, wantWait: "2500"},
+		{name: "packet timeout past a tiny deadline", count: 5, packetTimeout: time.Second, deadline: 2 * time.Millisecond, wantWait: "1"},
+		{name: "at least a millisecond", count: 5, deadline: 2 * time.Millisecond, wantWait: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := buildPingArgs("windows", "192.0.2.1", tt.count, tt.packetTimeout, tt.deadline)
+			i := slices.Index(args, "-w")
+			if i < 0 || i+1 >= len(args) || args[i+1] != tt.wantWait {
+				t.Errorf("buildPingArgs() = %q, want -w %s", args, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestSystemPingArgs(t *testing.T) {
+	tests := []struct {
+		name          string
+		goos          string
+		ip            string
+		packetTimeout time.Duration
+		interval      time.Duration
+		want          []string
+	}{
+		{name: "linux", goos: "linux", ip: "192.0.2.1", want: []string{"-c", "3", "-w", "5", "192.0.2.1"}},
+		{name: "linux v6", goos: "linux", ip: "2001:db8::1", want: []string{"-6", "-c", "3", "-w", "5", "2001:db8::1"}},
+		{name: "linux packet timeout", goos: "linux", ip: "192.0.2.1", packetTimeout: 1500 * time.Millisecond,
+			want: []string{"-c", "3", "-w", "5", "-W", "2", "192.0.2.1"}},
+		{name: "linux interval", goos: "linux", ip: "192.0.2.1", interval: 200 * time.Millisecond,
+			want: []string{"-i", "0.2", "-c", "3", "-w", "5", "192.0.2.1"}},
+		{name: "freebsd uses linux flags", goos: "freebsd", ip: "192.0.2.1", want: []string{"-c", "3", "-w", "5", "192.0.2.1"}},
+		{name: "darwin", goos: "darwin", ip: "192.0.2.1", want: []string{"-c", "3", "-t", "5", "192.0.2.1"}},
+		{name: "darwin v6 without deadline flag", goos: "darwin", ip: "2001:db8::1", want: []string{"-c", "3", "2001:db8::1"}},
+		{name: "darwin packet timeout in ms", goos: "darwin", ip: "192.0.2.1", packetTimeout: 1500 * time.Millisecond,
+			want: []string{"-c", "3", "-t", "5", "-W", "1500", "192.0.2.1"}},
+		{name: "windows v6", goos: "windows", ip: "2001:db8::1", want: []string{"-6", "-n", "3", "-w", "1500", "2001:db8::1"}},
+		{name: "windows ignores interval", goos: "windows", ip: "192.0.2.1", interval: time.Second,
+			want: []string{"-n", "3", "-w", "1500", "192.0.2.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.PingCount = 3
+			cfg.PingDeadline = 4500 * time.Millisecond
+			cfg.PingPacketTimeout = tt.packetTimeout
+			cfg.PingInterval = tt.interval
+
+			if got := systemPingArgs(tt.goos, cfg, tt.ip); !slices.Equal(got, tt.want) {
+				t.Errorf("systemPingArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPingWithSystem(t *testing.T) {
+	tests := []struct {
+		name          string
+		script        string
+		wantErr       error
+		wantRetryable bool
+		wantRtt       float64
+	}{
+		{name: "not installed", wantErr: exec.ErrNotFound},
+		{
+			name: "replies",
+			script: `echo "3 packets transmitted, 3 received, 0% packet loss, time 2003ms"
+echo "rtt min/avg/max/mdev = 1.000/2.000/3.000/0.500 ms"`,
+			wantRtt: 2,
+		},
+		{
+			name: "no reply",
+			script: `echo "3 packets transmitted, 0 received, 100% packet loss, time 2003ms"
+exit 1`,
+			wantErr:       errNoResponse,
+			wantRetryable: true,
+		},
+		{name: "broken", script: `echo "ping: socket: Operation not permitted"; exit 2`, wantRetryable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("PATH", dir)
+			if tt.script != "" {
+				writeScript(t, filepath.Join(dir, "ping"), tt.script)
+			}
+			cfg := testConfig("https://kuma.example.com")
+			cfg.PingCount = 3
+
+			result, err := pingWithSystem(context.Background(), cfg, "192.0.2.1")
+			if tt.wantErr == nil && !tt.wantRetryable {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.AvgRttMs != tt.wantRtt {
+					t.Errorf("AvgRttMs = %v, want %v", result.AvgRttMs, tt.wantRtt)
+				}
+				return
+			}
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("pingWithSystem() = %v, want %v", err, tt.wantErr)
+			}
+			if isRetryable(err) != tt.wantRetryable {
+				t.Errorf("retryable = %t, want %t", isRetryable(err), tt.wantRetryable)
+			}
+		})
+	}
+}
+
+// writeScript writes an executable shell script standing in for ping.
+func writeScript(t *testing.T, path, body string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSystemPingPath(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "custom-ping")
+	writeScript(t, custom, `echo "rtt min/avg/max/mdev = 4.000/5.000/6.000/0.500 ms"`)
+
+	tests := []struct {
+		name    string
+		path    string
+		wantRtt float64
+		wantErr bool
+	}{
+		{name: "custom binary", path: custom, wantRtt: 5},
+		{name: "missing binary", path: filepath.Join(dir, "missing"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Nothing to fall back to
+			t.Setenv("PATH", t.TempDir())
+			cfg := testConfig("https://kuma.example.com")
+			cfg.SystemPingPath = tt.path
+
+			result, err := pingWithSystem(context.Background(), cfg, "192.0.2.1")
+			if tt.wantErr {
+				if err == nil || isRetryable(err) || !strings.Contains(err.Error(), "not found") {
+					t.Fatalf("pingWithSystem() = %v, want a terminal not found error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.AvgRttMs != tt.wantRtt {
+				t.Errorf("AvgRttMs = %v, want %v", result.AvgRttMs, tt.wantRtt)
+			}
+		})
+	}
+}
+
+func TestSystemPingCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		goos  string
+		ip    string
+		path  string
+		path6 string
+		want  string
+	}{
+		{name: "linux v4", goos: "linux", ip: "192.0.2.1", want: "ping"},
+		{name: "linux v6", goos: "linux", ip: "2001:db8::1", want: "ping"},
+		{name: "darwin v4", goos: "darwin", ip: "192.0.2.1", want: "ping"},
+		{name: "darwin v6", goos: "darwin", ip: "2001:db8::1", want: "ping6"},
+		{name: "custom path", goos: "darwin", ip: "2001:db8::1", path: "/opt/ping", want: "/opt/ping"},
+		{name: "custom v6 path", goos: "linux", ip: "2001:db8::1", path: "/opt/ping", path6: "/opt/ping6", want: "/opt/ping6"},
+		{name: "custom v6 path for v4", goos: "linux", ip: "192.0.2.1", path6: "/opt/ping6", want: "ping"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("https://kuma.example.com")
+			cfg.SystemPingPath = tt.path
+			cfg.SystemPing6Path = tt.path6
+
+			if got := systemPingCommand(tt.goos, cfg, tt.ip); got != tt.want {
+				t.Errorf("systemPingCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
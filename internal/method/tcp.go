@@ -0,0 +1,86 @@
+package method
+
+import (
+	"context"
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"math"
+	"net"
+	"slices"
+	"time"
+)
+
+// pingWithTCP measures the time to establish a TCP connection to ip:port,
+// repeating PingCount times like an ICMP ping would.
+func pingWithTCP(ctx context.Context, cfg model.Config, ip, port string) (model.PingResult, error) {
+	dialer := net.Dialer{Timeout: packetTimeout(cfg)}
+	var rtts []float64
+	var lastErr error
+
+	for i := 0; i < cfg.PingCount; i++ {
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+		if err != nil {
+			if ctx.Err() != nil {
+				return model.PingResult{}, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		rtts = append(rtts, time.Since(start).Seconds()*1000)
+		_ = conn.Close()
+	}
+
+	if len(rtts) == 0 {
+		err := fmt.Errorf("tcp connect to %s failed: %w", net.JoinHostPort(ip, port), lastErr)
+		cfg.Logger("ERROR", err)
+		return model.PingResult{}, err
+	}
+
+	result := summarizeRtts(rtts, cfg.PingCount)
+	if cfg.TrimOutliers {
+		result.AvgRttMs = trimmedMean(rtts)
+	}
+
+	return result, nil
+}
+
+// summarizeRtts builds a PingResult from individual round trip samples in ms.
+func summarizeRtts(rtts []float64, sent int) model.PingResult {
+	var sum float64
+	for _, rtt := range rtts {
+		sum += rtt
+	}
+	avg := sum / float64(len(rtts))
+
+	var variance float64
+	for _, rtt := range rtts {
+		variance += (rtt - avg) * (rtt - avg)
+	}
+
+	return model.PingResult{
+		AvgRttMs:   avg,
+		MinRttMs:   slices.Min(rtts),
+		MaxRttMs:   slices.Max(rtts),
+		PacketLoss: float64(sent-len(rtts)) / float64(sent) * 100,
+		Jitter:     math.Sqrt(variance / float64(len(rtts))),
+		Sent:       sent,
+		Recv:       len(rtts),
+	}
+}
+
+// trimmedMean averages rtts without the lowest and highest sample. Fewer
+// than three samples are averaged as is.
+func trimmedMean(rtts []float64) float64 {
+	sorted := slices.Clone(rtts)
+	slices.Sort(sorted)
+	if len(sorted) >= 3 {
+		sorted = sorted[1 : len(sorted)-1]
+	}
+
+	var sum float64
+	for _, rtt := range sorted {
+		sum += rtt
+	}
+	return sum / float64(len(sorted))
+}
@@ -0,0 +1,26 @@
+package method
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net/http"
+)
+
+// setTraceHeaders adds a fresh W3C traceparent with InjectTraceparent and a
+// random request id in CorrelationIDHeader when set, so a report can be
+// found in proxy and server logs.
+func setTraceHeaders(cfg model.Config, req *http.Request) {
+	if cfg.InjectTraceparent {
+		req.Header.Set("traceparent", "00-"+randomHex(16)+"-"+randomHex(8)+"-01")
+	}
+	if cfg.CorrelationIDHeader != "" {
+		req.Header.Set(cfg.CorrelationIDHeader, randomHex(16))
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
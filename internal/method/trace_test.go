@@ -0,0 +1,67 @@
+package method
+
+import (
+	"context"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+var traceparentPattern = regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`)
+
+func TestSendReportTraceHeaders(t *testing.T) {
+	tests := []struct {
+		name            string
+		traceparent     bool
+		correlation     string
+		wantTraceparent bool
+		wantCorrelation string
+	}{
+		{name: "none"},
+		{name: "traceparent", traceparent: true, wantTraceparent: true},
+		{name: "correlation id", correlation: "X-Request-Id", wantCorrelation: "X-Request-Id"},
+		{name: "both", traceparent: true, correlation: "X-Correlation-Id", wantTraceparent: true, wantCorrelation: "X-Correlation-Id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := make(chan http.Header, 2)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headers <- r.Header
+			}))
+			defer srv.Close()
+
+			cfg := testConfig(srv.URL)
+			cfg.InjectTraceparent = tt.traceparent
+			cfg.CorrelationIDHeader = tt.correlation
+			hb := model.Heartbeat{Status: statusUp, Msg: "OK"}
+			for range 2 {
+				if err := sendReport(context.Background(), cfg, srv.Client(), hb); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			first, second := <-headers, <-headers
+			traceparent := first.Get("traceparent")
+			if got := traceparent != ""; got != tt.wantTraceparent {
+				t.Fatalf("traceparent %q, want one %t", traceparent, tt.wantTraceparent)
+			}
+			if tt.wantTraceparent {
+				if !traceparentPattern.MatchString(traceparent) {
+					t.Errorf("traceparent %q is not W3C", traceparent)
+				}
+				if traceparent == second.Get("traceparent") {
+					t.Error("traceparent reused across requests")
+				}
+			}
+			if tt.wantCorrelation != "" {
+				id := first.Get(tt.wantCorrelation)
+				if len(id) != 32 || id == second.Get(tt.wantCorrelation) {
+					t.Errorf("%s %q then %q, want a fresh id per request", tt.wantCorrelation, id, second.Get(tt.wantCorrelation))
+				}
+			}
+		})
+	}
+}
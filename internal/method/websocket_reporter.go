@@ -0,0 +1,150 @@
+package method
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+	"git.ghink.net/ghink/kuma-repoter/internal/version"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	wsMinBackoff = time.Second
+	wsMaxBackoff = time.Minute
+)
+
+// wsReporter keeps one WebSocket connection to ReportURL open and sends every
+// heartbeat on it as a JSON message. A dropped connection is redialled on the
+// next report, backing off exponentially while the endpoint stays away.
+type wsReporter struct {
+	cfg    model.Config
+	config *websocket.Config
+
+	mu       sync.Mutex
+	conn     *websocket.Conn
+	backoff  time.Duration
+	nextDial time.Time
+}
+
+// NewWebSocketReporter returns a Reporter pushing heartbeats over a persistent
+// ws:// or wss:// connection to cfg.ReportURL.
+func NewWebSocketReporter(cfg model.Config) (model.Reporter, error) {
+	location, err := url.Parse(cfg.ReportURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if location.Scheme != "ws" && location.Scheme != "wss" {
+		return nil, fmt.Errorf("websocket transport needs a ws:// or wss:// URL, got %q", location.Scheme)
+	}
+
+	origin := *location
+	origin.Scheme = "http"
+	if location.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+	config, err := websocket.NewConfig(location.String(), origin.String())
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	config.TlsConfig = tlsConfig
+	config.Header = http.Header{}
+	config.Header.Set("User-Agent", orDefault(cfg.UserAgent, "kuma-reporter/"+version.Version))
+	for key, value := range cfg.ReportHeaders {
+		config.Header.Set(key, value)
+	}
+
+	return &wsReporter{cfg: cfg, config: config}, nil
+}
+
+func (r *wsReporter) Report(ctx context.Context, hb model.Heartbeat) error {
+	payload := newReportPayload(r.cfg, hb.Status, hb.Msg, hb.Result)
+	if r.cfg.DryRun {
+		r.cfg.Logger("INFO", "Dry run, not sending over websocket: ", RedactURL(r.config.Location), " ", fmt.Sprintf("%+v", payload))
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conn, err := r.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline := clockOf(r.cfg).Now().Add(r.cfg.HTTPTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = conn.SetWriteDeadline(deadline)
+
+	if err := websocket.JSON.Send(conn, payload); err != nil {
+		_ = conn.Close()
+		r.conn = nil
+		err = retryable(fmt.Errorf("websocket send failed: %w", err))
+		r.cfg.Logger("ERROR", err)
+		return err
+	}
+	r.cfg.Logger("DEBUG", "Heartbeat sent over websocket")
+
+	return nil
+}
+
+// connect returns the open connection or dials a new one unless the backoff
+// after a failed dial has not passed yet. r.mu must be held.
+func (r *wsReporter) connect(ctx context.Context) (*websocket.Conn, error) {
+	if r.conn != nil {
+		return r.conn, nil
+	}
+	if wait := r.nextDial.Sub(clockOf(r.cfg).Now()); wait > 0 {
+		return nil, retryable(fmt.Errorf("websocket reconnect backing off for %s", wait.Round(time.Millisecond)))
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, r.cfg.HTTPTimeout)
+	defer cancel()
+
+	conn, err := r.config.DialContext(dialCtx)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		r.backoff = min(max(2*r.backoff, wsMinBackoff), wsMaxBackoff)
+		r.nextDial = clockOf(r.cfg).Now().Add(r.backoff)
+
+		var dialErr *websocket.DialError
+		if errors.As(err, &dialErr) {
+			err = dialErr.Err
+		}
+		err = retryable(fmt.Errorf("websocket connect to %s failed, retrying in %s: %w", RedactURL(r.config.Location), r.backoff, err))
+		r.cfg.Logger("ERROR", err)
+		return nil, err
+	}
+
+	r.conn = conn
+	r.backoff = 0
+	r.cfg.Logger("INFO", "Websocket connected to ", RedactURL(r.config.Location))
+	return conn, nil
+}
+
+// Close drops the connection, it is called when the target stops.
+func (r *wsReporter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
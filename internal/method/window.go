@@ -0,0 +1,45 @@
+package method
+
+import (
+	"math"
+	"slices"
+	"sync"
+)
+
+// rttWindow is a ring buffer of the last RTT samples of a target.
+type rttWindow struct {
+	mu      sync.Mutex
+	samples []float64
+	next    int
+	full    bool
+}
+
+func newRttWindow(size int) *rttWindow {
+	return &rttWindow{samples: make([]float64, size)}
+}
+
+// add records rtt and returns the p50 and p95 of the window.
+func (w *rttWindow) add(rtt float64) (float64, float64) {
+	w.mu.Lock()
+	w.samples[w.next] = rtt
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	sorted := slices.Clone(w.samples[:n])
+	w.mu.Unlock()
+
+	slices.Sort(sorted)
+	return percentile(sorted, 50), percentile(sorted, 95)
+}
+
+// percentile uses the nearest-rank method on sorted samples.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
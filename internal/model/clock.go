@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// Clock is the time source of the report loops and retries, so tests can
+// advance time instead of waiting for it.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the part of time.Ticker the daemon uses.
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
@@ -4,18 +4,234 @@ import (
 	"time"
 )
 
+type MonitorTarget struct {
+	Name          string `mapstructure:"name"`
+	Host          string `mapstructure:"host"`
+	ReportURL     string `mapstructure:"report_url"`
+	StatusMessage string `mapstructure:"status_message"`
+	// CheckURL replaces Config.CheckURL in the http check mode.
+	CheckURL string `mapstructure:"check_url"`
+	// ReportPeriod, MaxRetries and RetryDelay override the Config values when
+	// positive.
+	ReportPeriod time.Duration `mapstructure:"-"`
+	MaxRetries   int           `mapstructure:"max_retries"`
+	RetryDelay   time.Duration `mapstructure:"-"`
+}
+
+// ParamNames overrides the report query parameter names, empty fields keep
+// the Uptime Kuma defaults.
+type ParamNames struct {
+	Status string `mapstructure:"status"`
+	Msg    string `mapstructure:"msg"`
+	Ping   string `mapstructure:"ping"`
+	Loss   string `mapstructure:"loss"`
+	Jitter string `mapstructure:"jitter"`
+}
+
 type Config struct {
-	ReportURL     string
-	PingHost      string
-	ReportPeriod  time.Duration
-	MaxRetries    int
-	RetryDelay    time.Duration
-	PingCount     int
-	PingTimeout   time.Duration
-	HTTPTimeout   time.Duration
+	ReportURL string
+	// ReportURLs are tried in order when ReportURL fails, without Targets only.
+	// The reporter sticks to the URL that answered and probes the first one
+	// again every ReportURLReprobeInterval (5 minutes when zero).
+	ReportURLs               []string
+	ReportURLReprobeInterval time.Duration
+	PingHost                 string
+	ReportPeriod             time.Duration
+	// ReportPeriodJitter randomizes each period by up to ± this much.
+	ReportPeriodJitter time.Duration
+	// StartupDelay postpones the first report, plus a random part of up to
+	// StartupDelayJitter.
+	StartupDelay       time.Duration
+	StartupDelayJitter time.Duration
+	MaxRetries         int
+	RetryDelay         time.Duration
+	// MaxRetryDuration bounds the time a cycle spends retrying, zero only
+	// limits by MaxRetries.
+	MaxRetryDuration time.Duration
+	PingCount        int
+	// PingDeadline bounds a whole measurement of PingCount probes and
+	// PingPacketTimeout the wait for each reply, zero waits up to the deadline.
+	// The built-in ICMP ping only honours the deadline.
+	PingDeadline      time.Duration
+	PingPacketTimeout time.Duration
+	// Deprecated: PingTimeout is used as PingDeadline when that is unset.
+	PingTimeout time.Duration
+	// TrimOutliers reports the mean RTT without the fastest and slowest packet.
+	TrimOutliers bool
+	// PingInterval is the wait between packets, zero keeps the 1s default.
+	PingInterval time.Duration
+	// PingPacketSize is the ICMP payload size in bytes, zero keeps the default.
+	PingPacketSize int
+	HTTPTimeout    time.Duration
+	// ReportProtocol is "kuma" (default) or "healthchecks" for Healthchecks.io
+	// style ping URLs.
+	ReportProtocol string
+	// ReportTransport is "http" (default), one request per report, or
+	// "websocket" to keep a ws:// or wss:// ReportURL connection open and
+	// send JSON heartbeats on it.
+	ReportTransport string
+	// ReportMethod is "GET" (default, query parameters) or "POST" (JSON body).
+	ReportMethod string
+	// ReportBodyTemplate shapes POST bodies as a text/template over the
+	// check result, status and message. They are sent as application/json
+	// when the result is valid JSON and as text/plain otherwise.
+	ReportBodyTemplate string
+	// IncludeMetadata adds the reporter's source name and version to kuma
+	// reports. SourceName defaults to the hostname.
+	IncludeMetadata bool
+	SourceName      string
+	// UserAgent is sent with reports, "kuma-reporter/<version>" when empty.
+	UserAgent string
+	// IdempotencyKey sends the heartbeat ID as Idempotency-Key header, which
+	// lets POST reports be retried. Without it a failed POST is not repeated.
+	IdempotencyKey bool
+	// InjectTraceparent adds a new W3C traceparent header to every report and
+	// CorrelationIDHeader, when set, names a header carrying a random request id.
+	InjectTraceparent   bool
+	CorrelationIDHeader string
+	// ReportHeaders are added to every report request, e.g. Authorization.
+	ReportHeaders map[string]string
+	ParamNames    ParamNames
+	// OmitPingValue leaves the ping parameter out of kuma reports, e.g. when a
+	// tcp or http check's connect time should not show up as latency.
+	OmitPingValue bool
+	// AcceptableReportStatus lists the push response codes counted as success, {200} when empty.
+	AcceptableReportStatus []int
+	// ProxyURL routes reports through an http, https or socks5 proxy.
+	// When empty the HTTP_PROXY/HTTPS_PROXY environment is honoured.
+	ProxyURL string
+	// TLSSkipVerify disables certificate verification of the report endpoint.
+	// This is insecure, prefer TLSCACertFile for self-signed instances.
+	TLSSkipVerify bool
+	TLSCACertFile string
+	// TLSClientCert and TLSClientKey are a PEM key pair for mTLS endpoints.
+	TLSClientCert string
+	TLSClientKey  string
+	// DryRun logs the report requests instead of sending them.
+	DryRun bool
+	// Reporters receive every heartbeat besides the ReportURL push. Without a
+	// ReportURL the first one is the primary whose result counts.
+	Reporters     []Reporter
 	StatusMessage string
 	UseIPv4       bool
 	UseIPv6       bool
 	UseSystemPing bool
-	Logger        func(string, ...any)
+	// SystemPingPath and SystemPing6Path override the system ping binary,
+	// the latter for IPv6 addresses. Both default to "ping" from PATH.
+	SystemPingPath  string
+	SystemPing6Path string
+	// AutoFallbackPing uses the built-in ping when the system ping binary is missing.
+	AutoFallbackPing bool
+	// FallbackToTCP retries a failed ICMP ping as a TCP connect to
+	// FallbackTCPPort and reports its latency.
+	FallbackToTCP   bool
+	FallbackTCPPort int
+	// PrivilegedPing uses raw ICMP sockets (root or cap_net_raw), false sends
+	// unprivileged UDP pings allowed by net.ipv4.ping_group_range.
+	PrivilegedPing bool
+	// DualStack pings one IPv4 and one IPv6 address and reports both, the
+	// target is only down when neither answers. UseIPv4/UseIPv6 are ignored.
+	DualStack bool
+	// PingAllIPs pings every resolved address instead of stopping at the first
+	// responder, reporting the fastest or, with PingAllIPsAggregate "mean", the mean.
+	PingAllIPs          bool
+	PingAllIPsAggregate string
+	// PingHostAggregate combines the hosts of a comma separated PingHost:
+	// "min" (default) reports the fastest, "max" the slowest and "avg" the mean
+	// RTT of the hosts that answered.
+	PingHostAggregate string
+	// HostOverrides pins host names to comma separated IPs, skipping DNS.
+	HostOverrides map[string]string
+	// DNSServer sends lookups to this server ("host" or "host:port") instead of
+	// the system resolver.
+	DNSServer string
+	// DNSCacheTTL reuses resolved addresses for this long, zero disables caching.
+	DNSCacheTTL time.Duration
+	// UseStaleDNSOnError falls back to the last resolved addresses when a lookup fails.
+	UseStaleDNSOnError bool
+	// CheckMode selects how the target is measured: "icmp" (default), "tcp" or "http".
+	// The tcp mode expects PingHost as host:port, the http mode uses CheckURL,
+	// which several Targets cannot share.
+	CheckMode string
+	CheckURL  string
+	// PingProvider replaces the check selected by CheckMode when set.
+	// SimulatedRTTs, used without a PingProvider, replays these RTTs in ms per
+	// target in a loop, a negative value being a lost ping, to test alerting
+	// without network access.
+	PingProvider  PingProvider
+	SimulatedRTTs []float64
+	// ExpectedStatusCodes lists accepted http check statuses, any 2xx/3xx when empty.
+	ExpectedStatusCodes []int
+	// LossDownThreshold counts a cycle whose packet loss (in percent) exceeds it
+	// as down, zero disables it. FlapThreshold holds back the down report
+	// until that many consecutive cycles were down, nothing is sent meanwhile.
+	LossDownThreshold float64
+	FlapThreshold     int
+	// SmoothingWindow reports the median RTT of the last N cycles instead of
+	// the raw value. Values up to 1 disable smoothing.
+	SmoothingWindow int
+	// DegradedLossThreshold marks a report as degraded when packet loss (in percent)
+	// exceeds it. Zero disables the check.
+	DegradedLossThreshold float64
+	Targets               []MonitorTarget
+	// DetectClockJumps warns when the wall clock jumps, e.g. after a suspend,
+	// and reports right away when a period or more was missed.
+	DetectClockJumps bool
+	// CircuitBreakerThreshold stops pushing to ReportURL after that many
+	// consecutive failed reports, probing again every CircuitProbeInterval.
+	// Zero disables the breaker.
+	CircuitBreakerThreshold int
+	CircuitProbeInterval    time.Duration
+	// MaxReportsPerMinute caps the reports of all targets together, excess
+	// reports are dropped. Zero disables the limit.
+	MaxReportsPerMinute int
+	// MinReportGap skips a tick when the target's previous cycle finished less
+	// than this long before, e.g. after retrying for most of a period. Zero
+	// disables the check.
+	MinReportGap time.Duration
+	// MaxConcurrentReports caps running reports per target, further ticks are skipped.
+	MaxConcurrentReports int
+	// ShutdownTimeout bounds how long Daemon waits for in-flight reports on exit.
+	ShutdownTimeout time.Duration
+	// ReportDownOnShutdown replaces the final report of a graceful shutdown
+	// with a down beat carrying ShutdownMessage ("Reporter stopped" when
+	// empty), so a maintenance stop shows up at once.
+	ReportDownOnShutdown bool
+	ShutdownMessage      string
+	// StateFilePath receives the latest result of every target as JSON after each cycle.
+	StateFilePath string
+	// MetricsListenAddr serves Prometheus metrics at /metrics when set, e.g. ":9090".
+	MetricsListenAddr string
+	// HealthListenAddr serves /healthz and /readyz when set, sharing the
+	// metrics listener when both addresses are equal.
+	HealthListenAddr string
+	// OTLPEndpoint exports a span per report cycle and the check metrics to
+	// this OTLP/HTTP collector, e.g. "http://localhost:4318". It needs a
+	// binary built with the otel tag.
+	OTLPEndpoint string
+	// LogLevel is the minimum level logged: DEBUG, INFO, WARN, ERROR or FATAL.
+	LogLevel string
+	// LogFormat lays out the lines of the default and file loggers with the
+	// placeholders {time}, {level}, {target} and {msg}. Empty keeps
+	// "{time} [{level}] {msg}".
+	LogFormat string
+	// Clock drives the report schedule and retry waits, the real clock when
+	// nil. Tests can pass a fake one to advance time deterministically.
+	Clock Clock
+	// OnResult is called after every report cycle. It runs on the report
+	// goroutine, so it should return quickly; panics are recovered.
+	OnResult func(ReportResult)
+	// Quiet discards all logging, taking precedence over Logger and LogFile.
+	Quiet bool
+	// LogCaller adds the source file:line to every log line.
+	LogCaller bool
+	// LogFile sends the default logger to this file instead of stdout. It is
+	// rotated past LogMaxSizeMB (zero disables rotation), keeping LogMaxBackups
+	// old files. Ignored when Logger is set.
+	LogFile       string
+	LogMaxSizeMB  int
+	LogMaxBackups int
+	// Logger receives every log line as a level and fmt.Sprint arguments. It is
+	// called concurrently from the report goroutines and must be safe for that.
+	Logger func(string, ...any)
 }
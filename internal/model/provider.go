@@ -0,0 +1,10 @@
+package model
+
+import "context"
+
+// PingProvider measures a host in place of the built-in checks, e.g. to
+// script results for testing an alerting pipeline. Implementations must be
+// safe for concurrent use.
+type PingProvider interface {
+	Ping(ctx context.Context, host string) (PingResult, error)
+}
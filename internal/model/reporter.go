@@ -0,0 +1,19 @@
+package model
+
+import "context"
+
+// Heartbeat is what a Reporter receives once per report cycle.
+type Heartbeat struct {
+	// ID is unique per heartbeat and kept across retries of it.
+	ID     string
+	Host   string
+	Status string
+	Msg    string
+	Result PingResult
+}
+
+// Reporter delivers heartbeats to a backend such as Uptime Kuma, a chat
+// webhook or a file. Implementations must be safe for concurrent use.
+type Reporter interface {
+	Report(ctx context.Context, hb Heartbeat) error
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// PingResult holds the statistics of one measurement. Min and max are zero
+// when the check mode cannot provide them.
+type PingResult struct {
+	AvgRttMs   float64
+	MinRttMs   float64
+	MaxRttMs   float64
+	PacketLoss float64
+	// Jitter is the standard deviation of the RTT samples.
+	Jitter float64
+	// Sent and Recv count the probes sent and answered.
+	Sent int
+	Recv int
+	IP   string
+	// V4 and V6 hold the per family results in DualStack mode, nil when the
+	// family has no address or did not answer.
+	V4 *PingResult
+	V6 *PingResult
+	// Fallback is set when ICMP failed and a TCP connect answered instead.
+	Fallback bool
+}
+
+// ReportResult describes the outcome of one report cycle.
+type ReportResult struct {
+	Host   string
+	Status string
+	// Success is true when the primary sink accepted the heartbeat.
+	Success bool
+	Result  PingResult
+	// Attempts counts the measurements made, including retries.
+	Attempts int
+	Err      error
+	Time     time.Time
+}
@@ -0,0 +1,248 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaxPingPacketSize is the largest ICMP payload fitting an IPv4 packet.
+const MaxPingPacketSize = 65507
+
+// Validate reports every invalid field of the config at once.
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.ReportPeriod <= 0 {
+		errs = append(errs, errors.New("report period must be positive"))
+	}
+	if c.ReportPeriodJitter < 0 || (c.ReportPeriodJitter > 0 && c.ReportPeriodJitter >= c.ReportPeriod) {
+		errs = append(errs, errors.New("report period jitter must be between zero and the report period"))
+	}
+	if c.MinReportGap < 0 || (c.MinReportGap > 0 && c.MinReportGap >= c.ReportPeriod) {
+		errs = append(errs, errors.New("min report gap must be between zero and the report period"))
+	}
+	if c.StartupDelay < 0 || c.StartupDelayJitter < 0 {
+		errs = append(errs, errors.New("startup delay and its jitter must not be negative"))
+	}
+	if c.MaxRetries <= 0 {
+		errs = append(errs, errors.New("max retries must be positive"))
+	}
+	if c.RetryDelay < 0 {
+		errs = append(errs, errors.New("retry delay must not be negative"))
+	}
+	if c.MaxRetryDuration < 0 {
+		errs = append(errs, errors.New("max retry duration must not be negative"))
+	}
+	deadline := c.PingDeadline
+	if deadline == 0 {
+		deadline = c.PingTimeout
+	}
+	if deadline <= 0 {
+		errs = append(errs, errors.New("ping deadline must be positive"))
+	}
+	if c.PingPacketTimeout < 0 {
+		errs = append(errs, errors.New("ping packet timeout must not be negative"))
+	}
+	if c.PingInterval < 0 {
+		errs = append(errs, errors.New("ping interval must not be negative"))
+	} else if c.PingCount > 0 && deadline > 0 && time.Duration(c.PingCount-1)*c.PingInterval >= deadline {
+		errs = append(errs, errors.New("ping interval times ping count must fit in the ping deadline"))
+	}
+	if c.PingPacketSize < 0 || c.PingPacketSize > MaxPingPacketSize {
+		errs = append(errs, fmt.Errorf("ping packet size must be between 0 and %d", MaxPingPacketSize))
+	}
+	if c.HTTPTimeout <= 0 {
+		errs = append(errs, errors.New("http timeout must be positive"))
+	}
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("shutdown timeout must not be negative"))
+	}
+	if c.DNSCacheTTL < 0 {
+		errs = append(errs, errors.New("dns cache ttl must not be negative"))
+	}
+	if c.CircuitBreakerThreshold < 0 {
+		errs = append(errs, errors.New("circuit breaker threshold must not be negative"))
+	}
+	if c.CircuitBreakerThreshold > 0 && c.CircuitProbeInterval <= 0 {
+		errs = append(errs, errors.New("circuit probe interval must be positive"))
+	}
+	if c.MaxReportsPerMinute < 0 {
+		errs = append(errs, errors.New("max reports per minute must not be negative"))
+	}
+	if c.MaxConcurrentReports < 0 {
+		errs = append(errs, errors.New("max concurrent reports must not be negative"))
+	}
+	if c.LogMaxSizeMB < 0 || c.LogMaxBackups < 0 {
+		errs = append(errs, errors.New("log file size and backups must not be negative"))
+	}
+	if c.LossDownThreshold < 0 || c.LossDownThreshold > 100 {
+		errs = append(errs, errors.New("loss down threshold must be between 0 and 100"))
+	}
+	if c.FlapThreshold < 0 {
+		errs = append(errs, errors.New("flap threshold must not be negative"))
+	}
+	if c.DegradedLossThreshold < 0 || c.DegradedLossThreshold > 100 {
+		errs = append(errs, errors.New("degraded loss threshold must be between 0 and 100"))
+	}
+	if !c.UseIPv4 && !c.UseIPv6 {
+		errs = append(errs, errors.New("at least one of IPv4 and IPv6 must be enabled"))
+	}
+	if c.FallbackToTCP && (c.FallbackTCPPort <= 0 || c.FallbackTCPPort > 65535) {
+		errs = append(errs, errors.New("fallback tcp port must be between 1 and 65535"))
+	}
+	for host, value := range c.HostOverrides {
+		for _, field := range strings.Split(value, ",") {
+			if net.ParseIP(strings.TrimSpace(field)) == nil {
+				errs = append(errs, fmt.Errorf("host override %s: invalid IP %q", host, field))
+			}
+		}
+	}
+	if c.OTLPEndpoint != "" {
+		if u, err := url.Parse(c.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("otlp endpoint %q must be an http or https URL", c.OTLPEndpoint))
+		}
+	}
+	switch c.PingHostAggregate {
+	case "", "min", "max", "avg":
+	default:
+		errs = append(errs, fmt.Errorf("unknown ping host aggregate %q, want min, max or avg", c.PingHostAggregate))
+	}
+	switch c.CheckMode {
+	case "", "icmp", "tcp":
+	case "http":
+		// Targets without their own check url probe the top-level one
+		shared := 0
+		for i, target := range c.Targets {
+			if target.CheckURL == "" {
+				shared++
+			} else if err := validateURL(target.CheckURL); err != nil {
+				errs = append(errs, fmt.Errorf("target %d check url: %w", i, err))
+			}
+		}
+		if len(c.Targets) == 0 || shared > 0 {
+			if err := validateURL(c.CheckURL); err != nil {
+				errs = append(errs, fmt.Errorf("check url: %w", err))
+			}
+		}
+		if shared > 1 {
+			errs = append(errs, fmt.Errorf("%d targets would report the same check url, set check_url per target", shared))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown check mode %q, want icmp, tcp or http", c.CheckMode))
+	}
+
+	if len(c.Targets) == 0 {
+		if err := c.validateReportURL(c.ReportURL); err != nil && (c.ReportURL != "" || len(c.Reporters) == 0 && len(c.ReportURLs) == 0) {
+			errs = append(errs, fmt.Errorf("report url: %w", err))
+		}
+		for i, reportURL := range c.ReportURLs {
+			if err := c.validateReportURL(reportURL); err != nil {
+				errs = append(errs, fmt.Errorf("report url %d: %w", i, err))
+			}
+		}
+		if len(c.ReportURLs) > 0 && c.ReportTransport == "websocket" {
+			errs = append(errs, errors.New("report urls failover needs the http transport"))
+		}
+		if c.PingHost == "" && c.CheckMode != "http" {
+			errs = append(errs, errors.New("ping host is required"))
+		} else if err := c.validateTCPHosts(c.PingHost); err != nil {
+			errs = append(errs, fmt.Errorf("ping host: %w", err))
+		}
+	}
+	for i, target := range c.Targets {
+		if err := c.validateReportURL(target.ReportURL); err != nil && (target.ReportURL != "" || len(c.Reporters) == 0) {
+			errs = append(errs, fmt.Errorf("target %d report url: %w", i, err))
+		}
+		if target.Host == "" && c.CheckMode != "http" {
+			errs = append(errs, fmt.Errorf("target %d host is required", i))
+		} else if err := c.validateTCPHosts(target.Host); err != nil {
+			errs = append(errs, fmt.Errorf("target %d host: %w", i, err))
+		}
+		if target.ReportPeriod < 0 || (target.ReportPeriod > 0 && c.ReportPeriodJitter >= target.ReportPeriod) {
+			errs = append(errs, fmt.Errorf("target %d report period must be positive and exceed the jitter", i))
+		}
+		if target.ReportPeriod > 0 && c.MinReportGap >= target.ReportPeriod {
+			errs = append(errs, fmt.Errorf("target %d report period must exceed the min report gap", i))
+		}
+		if target.MaxRetries < 0 {
+			errs = append(errs, fmt.Errorf("target %d max retries must not be negative", i))
+		}
+		if target.RetryDelay < 0 {
+			errs = append(errs, fmt.Errorf("target %d retry delay must not be negative", i))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateTCPHosts checks that every host of a comma separated list is a
+// host:port in the tcp check mode.
+func (c Config) validateTCPHosts(hosts string) error {
+	if c.CheckMode != "tcp" {
+		return nil
+	}
+
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(host); err != nil || port == "" {
+			return fmt.Errorf("%q must be host:port in the tcp check mode", host)
+		}
+	}
+
+	return nil
+}
+
+func validateURL(raw string) error {
+	if raw == "" {
+		return errors.New("missing")
+	}
+	if _, err := url.ParseRequestURI(raw); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateReportURL checks the normalized URL has a scheme the transport can
+// push to and somewhere to push.
+func (c Config) validateReportURL(raw string) error {
+	if err := validateURL(NormalizeReportURL(raw)); err != nil {
+		return err
+	}
+
+	u, _ := url.Parse(NormalizeReportURL(raw))
+	schemes := []string{"http", "https", "unix"}
+	if c.ReportTransport == "websocket" {
+		schemes = []string{"ws", "wss"}
+	}
+	if !slices.Contains(schemes, u.Scheme) {
+		return fmt.Errorf("unsupported scheme %q, expected one of %s", u.Scheme, strings.Join(schemes, ", "))
+	}
+	if u.Scheme == "unix" {
+		if socket, _, _ := strings.Cut(strings.TrimPrefix(raw, "unix://"), ":"); socket == "" {
+			return errors.New("missing socket path")
+		}
+	} else if u.Host == "" {
+		return errors.New("missing host")
+	}
+
+	return nil
+}
+
+// NormalizeReportURL assumes https for a report URL given without a scheme,
+// e.g. "kuma.example.com/api/push/xxxx".
+func NormalizeReportURL(raw string) string {
+	if raw == "" || strings.Contains(raw, "://") {
+		return raw
+	}
+
+	return "https://" + raw
+}
@@ -0,0 +1,181 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a config that passes Validate, for the cases to break.
+func validConfig() Config {
+	return Config{
+		ReportURL:    "https://kuma.example.com/api/push/test",
+		PingHost:     "example.com",
+		PingCount:    1,
+		PingDeadline: time.Second,
+		ReportPeriod: time.Minute,
+		MaxRetries:   1,
+		HTTPTimeout:  time.Second,
+		UseIPv4:      true,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Config)
+		wantErr []string
+	}{
+		{name: "valid", mutate: func(*Config) {}},
+		{name: "zero period", mutate: func(c *Config) { c.ReportPeriod = 0 }, wantErr: []string{"report period must be positive"}},
+		{name: "zero retries", mutate: func(c *Config) { c.MaxRetries = 0 }, wantErr: []string{"max retries must be positive"}},
+		{name: "no deadline", mutate: func(c *Config) { c.PingDeadline = 0 }, wantErr: []string{"ping deadline must be positive"}},
+		{name: "deprecated timeout", mutate: func(c *Config) { c.PingDeadline, c.PingTimeout = 0, time.Second }},
+		{name: "no family", mutate: func(c *Config) { c.UseIPv4 = false }, wantErr: []string{"IPv4 and IPv6"}},
+		{name: "no ping host", mutate: func(c *Config) { c.PingHost = "" }, wantErr: []string{"ping host is required"}},
+		{name: "no report url", mutate: func(c *Config) { c.ReportURL = "" }, wantErr: []string{"report url"}},
+		{name: "packet size", mutate: func(c *Config) { c.PingPacketSize = 1400 }},
+		{name: "largest packet size", mutate: func(c *Config) { c.PingPacketSize = MaxPingPacketSize }},
+		{name: "oversized packet", mutate: func(c *Config) { c.PingPacketSize = MaxPingPacketSize + 1 }, wantErr: []string{"ping packet size"}},
+		{name: "negative packet size", mutate: func(c *Config) { c.PingPacketSize = -1 }, wantErr: []string{"ping packet size"}},
+		{name: "host pool", mutate: func(c *Config) { c.PingHost, c.PingHostAggregate = "a.example.com, b.example.com", "avg" }},
+		{name: "unknown aggregate", mutate: func(c *Config) { c.PingHostAggregate = "median" }, wantErr: []string{`unknown ping host aggregate "median"`}},
+		{
+			name:    "every error at once",
+			mutate:  func(c *Config) { c.ReportPeriod, c.HTTPTimeout, c.LossDownThreshold = 0, 0, 101 },
+			wantErr: []string{"report period", "http timeout", "loss down threshold"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.mutate(&cfg)
+
+			err := cfg.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want %q", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() = %v, want an error containing %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateMinReportGap(t *testing.T) {
+	tests := []struct {
+		name    string
+		gap     time.Duration
+		targets []MonitorTarget
+		wantErr string
+	}{
+		{name: "unset", gap: 0},
+		{name: "below the period", gap: 30 * time.Second},
+		{name: "negative", gap: -time.Second, wantErr: "min report gap"},
+		{name: "period", gap: time.Minute, wantErr: "min report gap"},
+		{
+			name: "below the target period",
+			gap:  30 * time.Second,
+			targets: []MonitorTarget{
+				{Host: "a.example.com", ReportURL: "https://kuma.example.com/api/push/a", ReportPeriod: 45 * time.Second},
+			},
+		},
+		{
+			name: "target period override",
+			gap:  30 * time.Second,
+			targets: []MonitorTarget{
+				{Host: "a.example.com", ReportURL: "https://kuma.example.com/api/push/a"},
+				{Host: "b.example.com", ReportURL: "https://kuma.example.com/api/push/b", ReportPeriod: 20 * time.Second},
+			},
+			wantErr: "target 1 report period must exceed the min report gap",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.MinReportGap = tt.gap
+			cfg.Targets = tt.targets
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		reportURL string
+		transport string
+		wantErr   string
+	}{
+		{name: "https", reportURL: "https://kuma.example.com/api/push/test"},
+		{name: "http", reportURL: "http://10.0.0.5:3001/api/push/test"},
+		{name: "no scheme", reportURL: "kuma.example.com/api/push/test"},
+		{name: "unix socket", reportURL: "unix:///run/kuma.sock:/api/push/test"},
+		{name: "websocket", reportURL: "wss://kuma.example.com/push", transport: "websocket"},
+		{name: "ftp", reportURL: "ftp://kuma.example.com/api/push/test", wantErr: `unsupported scheme "ftp"`},
+		{name: "http over websocket", reportURL: "https://kuma.example.com/push", transport: "websocket", wantErr: `unsupported scheme "https"`},
+		{name: "no host", reportURL: "https:///api/push/test", wantErr: "missing host"},
+		{name: "no socket path", reportURL: "unix://:/api/push/test", wantErr: "missing socket path"},
+		{name: "empty", reportURL: "", wantErr: "report url: missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.ReportURL = tt.reportURL
+			cfg.ReportTransport = tt.transport
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizeReportURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: "kuma.example.com/api/push/test", want: "https://kuma.example.com/api/push/test"},
+		{raw: "https://kuma.example.com/api/push/test", want: "https://kuma.example.com/api/push/test"},
+		{raw: "http://kuma.example.com/api/push/test", want: "http://kuma.example.com/api/push/test"},
+		{raw: "unix:///run/kuma.sock:/api/push/test", want: "unix:///run/kuma.sock:/api/push/test"},
+		{raw: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := NormalizeReportURL(tt.raw); got != tt.want {
+				t.Errorf("NormalizeReportURL(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+// Package version holds the build information, set at build time with
+//
+//	go build -ldflags "-X git.ghink.net/ghink/kuma-repoter/internal/version.Version=v1.2.0 \
+//		-X git.ghink.net/ghink/kuma-repoter/internal/version.Commit=$(git rev-parse --short HEAD) \
+//		-X git.ghink.net/ghink/kuma-repoter/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/main
+package version
+
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// String formats the build information as "dev (commit unknown, built unknown)".
+func String() string {
+	return Version + " (commit " + Commit + ", built " + BuildDate + ")"
+}
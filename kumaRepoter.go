@@ -1,3 +1,6 @@
+// Package kumaRepoter is the library entry point of the reporter. It only
+// re-exports the internal packages, the reporting logic lives in
+// internal/method and the binary in cmd/main.
 package kumaRepoter
 
 import (
@@ -7,4 +10,46 @@ import (
 
 type Config = model.Config
 
+type MonitorTarget = model.MonitorTarget
+
+type ParamNames = model.ParamNames
+
+type Reporter = model.Reporter
+
+type PingProvider = model.PingProvider
+
+type Clock = model.Clock
+
+type Heartbeat = model.Heartbeat
+
+type ReportResult = model.ReportResult
+
 var Daemon = method.Daemon
+
+var DaemonWithReload = method.DaemonWithReload
+
+var ErrGracefulShutdown = method.ErrGracefulShutdown
+
+var RunOnce = method.RunOnce
+
+var ErrPingFailed = method.ErrPingFailed
+
+var ErrReportFailed = method.ErrReportFailed
+
+var NewJSONLogger = method.NewJSONLogger
+
+var NewFileLogger = method.NewFileLogger
+
+var NopLogger = method.NopLogger
+
+var RedactURL = method.RedactURL
+
+var NewHTTPReporter = method.NewHTTPReporter
+
+var NewWebSocketReporter = method.NewWebSocketReporter
+
+var NewPingProvider = method.NewPingProvider
+
+var NewSimulatedPingProvider = method.NewSimulatedPingProvider
+
+var NewFakeClock = method.NewFakeClock
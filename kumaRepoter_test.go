@@ -0,0 +1,81 @@
+package kumaRepoter
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestOnlyReExports keeps the root package a thin facade: its declarations
+// may only alias model types and re-export method or model values, any
+// logic belongs in internal/method.
+func TestOnlyReExports(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				checkReExport(t, fset, decl)
+			}
+		}
+	}
+}
+
+func checkReExport(t *testing.T, fset *token.FileSet, decl ast.Decl) {
+	t.Helper()
+
+	gen, ok := decl.(*ast.GenDecl)
+	if !ok {
+		t.Errorf("%s: functions belong in internal/method", fset.Position(decl.Pos()))
+		return
+	}
+
+	for _, spec := range gen.Specs {
+		pos := fset.Position(spec.Pos())
+		switch spec := spec.(type) {
+		case *ast.ImportSpec:
+		case *ast.TypeSpec:
+			if !spec.Assign.IsValid() || !isSelector(spec.Type, "model") {
+				t.Errorf("%s: type %s must be an alias of a model type", pos, spec.Name)
+			}
+		case *ast.ValueSpec:
+			if gen.Tok != token.VAR || len(spec.Values) != len(spec.Names) {
+				t.Errorf("%s: only vars re-exporting method or model values are allowed", pos)
+				continue
+			}
+			for i, value := range spec.Values {
+				if !isSelector(value, "method", "model") {
+					t.Errorf("%s: var %s must re-export a method or model value", pos, spec.Names[i])
+				}
+			}
+		}
+	}
+}
+
+// isSelector reports whether expr is pkg.Name for one of pkgs.
+func isSelector(expr ast.Expr, pkgs ...string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	ident, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return false
+	}
+
+	for _, pkg := range pkgs {
+		if ident.Name == pkg {
+			return true
+		}
+	}
+	return false
+}
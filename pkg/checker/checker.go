@@ -0,0 +1,21 @@
+// Package checker exposes the measurement used by the reporter without
+// sending any report, for embedding in custom flows.
+package checker
+
+import (
+	"context"
+	"git.ghink.net/ghink/kuma-repoter/internal/method"
+	"git.ghink.net/ghink/kuma-repoter/internal/model"
+)
+
+type Config = model.Config
+
+// Result holds the average RTT and jitter in ms, packet loss in percent
+// and the address that answered.
+type Result = model.PingResult
+
+// Check measures cfg.PingHost once according to cfg.CheckMode. Zero values
+// get the binary's defaults, see method.Check.
+func Check(ctx context.Context, cfg Config) (Result, error) {
+	return method.Check(ctx, cfg)
+}